@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type AlertState string
+
+const (
+	AlertFiring   AlertState = "firing"
+	AlertResolved AlertState = "resolved"
+)
+
+type AlertRule struct {
+	Name      string        `json:"name"`
+	Query     string        `json:"query"`
+	Window    time.Duration `json:"window"`
+	Threshold int           `json:"threshold"`
+}
+
+type AlertEvent struct {
+	Rule      string     `json:"rule"`
+	Query     string     `json:"query"`
+	State     AlertState `json:"state"`
+	Count     int        `json:"count"`
+	Threshold int        `json:"threshold"`
+	At        time.Time  `json:"at"`
+}
+
+func (e AlertEvent) String() string {
+	return fmt.Sprintf("ALERT %s %s count: %d threshold: %d at: %v", e.Rule, e.State, e.Count, e.Threshold, e.At)
+}
+
+type AlertSink interface {
+	Emit(event AlertEvent) error
+}
+
+type writerSink struct {
+	writer io.Writer
+}
+
+func (w writerSink) Emit(event AlertEvent) error {
+	_, err := w.writer.Write([]byte(event.String() + "\r\n"))
+	return err
+}
+
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func (w webhookSink) Emit(event AlertEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// alertQueueSize is how many events an asyncSink holds while its sink is
+// busy.
+const alertQueueSize = 256
+
+// asyncSink hands events to a goroutine that emits them to sink. Events are
+// emitted under the store lock, so a slow sink would otherwise stall every
+// command. Events that arrive while the queue is full are dropped.
+type asyncSink struct {
+	sink   AlertSink
+	events chan AlertEvent
+}
+
+func getNewAsyncSink(sink AlertSink, size int) *asyncSink {
+	async := &asyncSink{sink: sink, events: make(chan AlertEvent, size)}
+	go async.run()
+	return async
+}
+
+func (a *asyncSink) run() {
+	for event := range a.events {
+		if err := a.sink.Emit(event); err != nil {
+			fmt.Fprintf(os.Stderr, "alert sink: %v\r\n", err)
+		}
+	}
+}
+
+func (a *asyncSink) Emit(event AlertEvent) error {
+	select {
+	case a.events <- event:
+		return nil
+	default:
+		return fmt.Errorf("queue full, dropped %s event of rule %s", event.State, event.Rule)
+	}
+}
+
+// getSinkFromSpec builds a sink from "stdout", "stderr", "file:<path>" or an
+// http(s) webhook url.
+func getSinkFromSpec(spec string) (AlertSink, error) {
+	switch {
+	case spec == "" || spec == "stderr":
+		return writerSink{writer: os.Stderr}, nil
+	case spec == "stdout":
+		return writerSink{writer: os.Stdout}, nil
+	case strings.HasPrefix(spec, "file:"):
+		f, err := os.OpenFile(spec[len("file:"):], os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return writerSink{writer: f}, nil
+	case strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://"):
+		return getNewAsyncSink(webhookSink{url: spec, client: &http.Client{Timeout: 5 * time.Second}}, alertQueueSize), nil
+	}
+	return nil, fmt.Errorf("unknown alert sink %q", spec)
+}
+
+type ruleState struct {
+	rule    AlertRule
+	matches []time.Time
+	firing  bool
+}
+
+type AlertManager struct {
+	rules    map[string]*ruleState
+	sink     AlertSink
+	path     string
+	now      func() time.Time
+	tokenize Tokenizer
+}
+
+func getNewAlertManager() *AlertManager {
+	return &AlertManager{
+		rules:    map[string]*ruleState{},
+		now:      time.Now,
+		tokenize: getWordsFromData,
+	}
+}
+
+func (a *AlertManager) addRule(rule AlertRule) error {
+	if rule.Name == "" || rule.Query == "" {
+		return fmt.Errorf("rule needs a name and a query")
+	}
+	if rule.Window <= 0 {
+		return fmt.Errorf("rule window must be positive")
+	}
+	if rule.Threshold < 0 {
+		return fmt.Errorf("rule threshold can't be negative")
+	}
+	a.rules[rule.Name] = &ruleState{rule: rule}
+	return a.save()
+}
+
+func (a *AlertManager) deleteRule(name string) error {
+	if _, found := a.rules[name]; !found {
+		return fmt.Errorf("rule not found")
+	}
+	delete(a.rules, name)
+	return a.save()
+}
+
+func (a *AlertManager) listRules() []AlertRule {
+	rules := []AlertRule{}
+	for _, state := range a.rules {
+		rules = append(rules, state.rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Name < rules[j].Name
+	})
+	return rules
+}
+
+func (a *AlertManager) isFiring(name string) bool {
+	state, found := a.rules[name]
+	return found && state.firing
+}
+
+// observe is registered as an ingestion hook on the store.
+func (a *AlertManager) observe(log Log) {
+	now := a.now()
+	words := map[string]struct{}{}
+	for _, word := range a.tokenize(log.Data) {
+		words[word] = struct{}{}
+	}
+	for _, state := range a.rules {
+		if a.matches(words, state.rule.Query) {
+			state.matches = append(state.matches, now)
+		}
+	}
+	a.evaluate(now)
+}
+
+func (a *AlertManager) matches(words map[string]struct{}, query string) bool {
+	keys := a.tokenize(query)
+	if len(keys) == 0 {
+		return false
+	}
+	for _, key := range keys {
+		if _, found := words[key]; !found {
+			return false
+		}
+	}
+	return true
+}
+
+// evaluate drops matches that fell out of each rule's window and emits an
+// event for every rule whose state changed.
+func (a *AlertManager) evaluate(now time.Time) {
+	for _, state := range a.rules {
+		cutoff := now.Add(-state.rule.Window)
+		kept := 0
+		for kept < len(state.matches) && !state.matches[kept].After(cutoff) {
+			kept++
+		}
+		state.matches = state.matches[kept:]
+
+		firing := len(state.matches) > state.rule.Threshold
+		if firing == state.firing {
+			continue
+		}
+		state.firing = firing
+		event := AlertEvent{
+			Rule:      state.rule.Name,
+			Query:     state.rule.Query,
+			State:     AlertResolved,
+			Count:     len(state.matches),
+			Threshold: state.rule.Threshold,
+			At:        now,
+		}
+		if firing {
+			event.State = AlertFiring
+		}
+		a.emit(event)
+	}
+}
+
+func (a *AlertManager) emit(event AlertEvent) {
+	if a.sink == nil {
+		return
+	}
+	if err := a.sink.Emit(event); err != nil {
+		fmt.Fprintf(os.Stderr, "alert sink: %v\r\n", err)
+	}
+}
+
+func (a *AlertManager) save() error {
+	if a.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(a.listRules(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(a.path, data, 0644)
+}
+
+// load reads persisted rules from path and keeps saving to it afterwards.
+func (a *AlertManager) load(path string) error {
+	a.path = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var rules []AlertRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		a.rules[rule.Name] = &ruleState{rule: rule}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	events []AlertEvent
+}
+
+func (r *recordingSink) Emit(event AlertEvent) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+func TestAlertManager_observe(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	type entry struct {
+		offset time.Duration
+		data   string
+	}
+	tests := []struct {
+		name    string
+		rule    AlertRule
+		entries []entry
+		want    []AlertState
+	}{
+		{
+			"below threshold",
+			AlertRule{Name: "panics", Query: "panic", Window: time.Minute, Threshold: 2},
+			[]entry{{0, "panic here"}, {time.Second, "panic there"}},
+			nil,
+		},
+		{
+			"fires above threshold",
+			AlertRule{Name: "panics", Query: "panic", Window: time.Minute, Threshold: 1},
+			[]entry{{0, "panic here"}, {time.Second, "panic there"}, {2 * time.Second, "panic again"}},
+			[]AlertState{AlertFiring},
+		},
+		{
+			"non matching logs are ignored",
+			AlertRule{Name: "panics", Query: "panic", Window: time.Minute, Threshold: 1},
+			[]entry{{0, "all good"}, {time.Second, "still good"}},
+			nil,
+		},
+		{
+			"resolves once matches leave the window",
+			AlertRule{Name: "panics", Query: "panic", Window: time.Minute, Threshold: 1},
+			[]entry{{0, "panic here"}, {time.Second, "panic there"}, {2 * time.Minute, "all good"}},
+			[]AlertState{AlertFiring, AlertResolved},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &recordingSink{}
+			a := getNewAlertManager()
+			a.sink = sink
+			if err := a.addRule(tt.rule); err != nil {
+				t.Fatalf("addRule() error = %v", err)
+			}
+			for _, e := range tt.entries {
+				now := start.Add(e.offset)
+				a.now = func() time.Time { return now }
+				a.observe(Log{Data: e.data})
+			}
+			var got []AlertState
+			for _, event := range sink.events {
+				got = append(got, event.State)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("observe() events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAlertManager_persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	a := getNewAlertManager()
+	if err := a.load(path); err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	rule := AlertRule{Name: "panics", Query: "panic", Window: 5 * time.Minute, Threshold: 50}
+	if err := a.addRule(rule); err != nil {
+		t.Fatalf("addRule() error = %v", err)
+	}
+
+	b := getNewAlertManager()
+	if err := b.load(path); err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	if got := b.listRules(); !reflect.DeepEqual(got, []AlertRule{rule}) {
+		t.Errorf("listRules() = %v, want %v", got, []AlertRule{rule})
+	}
+}
+
+// blockedSink emits to events once release is closed.
+type blockedSink struct {
+	release chan struct{}
+	events  chan AlertEvent
+}
+
+func (b *blockedSink) Emit(event AlertEvent) error {
+	<-b.release
+	b.events <- event
+	return nil
+}
+
+func Test_asyncSink_Emit(t *testing.T) {
+	sink := &blockedSink{release: make(chan struct{}), events: make(chan AlertEvent, 3)}
+	async := getNewAsyncSink(sink, 1)
+	done := make(chan []error)
+	go func() {
+		errs := []error{}
+		for _, rule := range []string{"a", "b", "c"} {
+			errs = append(errs, async.Emit(AlertEvent{Rule: rule, State: AlertFiring}))
+		}
+		done <- errs
+	}()
+	var errs []error
+	select {
+	case errs = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Emit() waited for the sink")
+	}
+	if errs[0] != nil || errs[2] == nil {
+		t.Errorf("Emit() = %v, want the first event queued and the last dropped", errs)
+	}
+	close(sink.release)
+	if event := <-sink.events; event.Rule != "a" {
+		t.Errorf("first emitted event = %v, want rule a", event)
+	}
+}
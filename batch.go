@@ -0,0 +1,78 @@
+package main
+
+import "fmt"
+
+// batch collects the ADD lines sent between BEGIN and COMMIT. Nothing is
+// stored until COMMIT, and a single bad line makes the whole batch fail.
+type batch struct {
+	logs   []Log
+	lines  int
+	failed *CommandError
+}
+
+// fail remembers the first bad line of the batch.
+func (b *batch) fail(err error) {
+	if b.failed == nil {
+		commandErr := err.(*CommandError)
+		b.failed = newCommandError(commandErr.Code, "line %d: %s", b.lines, commandErr.Message)
+	}
+}
+
+func (s *Session) processBegin() (Response, error) {
+	if s.batch != nil {
+		return Response{}, newCommandError(ErrBadState, "a batch is already open")
+	}
+	s.batch = &batch{}
+	return Response{Status: StatusOK, text: []string{"OK"}}, nil
+}
+
+func (s *Session) queueAdd(command string) (Response, error) {
+	s.batch.lines++
+	log, err := parseAdd(command)
+	if err != nil {
+		s.batch.fail(err)
+		return Response{}, err
+	}
+	s.store.observeID(log.ID)
+	s.batch.logs = append(s.batch.logs, log)
+	return Response{Status: StatusOK, Results: []Result{{ID: log.ID}}}, nil
+}
+
+// queueAppend assigns the id right away so it can be returned, an aborted
+// batch just leaves a gap in the assigned ids.
+func (s *Session) queueAppend(command string) (Response, error) {
+	s.batch.lines++
+	data, err := parseAppend(command)
+	if err != nil {
+		s.batch.fail(err)
+		return Response{}, err
+	}
+	id := s.store.reserveID()
+	s.batch.logs = append(s.batch.logs, Log{ID: id, Data: data})
+	return Response{Status: StatusOK, Results: []Result{{ID: id}}, text: []string{string(id)}}, nil
+}
+
+func (s *Session) processCommit() (Response, error) {
+	if s.batch == nil {
+		return Response{}, newCommandError(ErrBadState, "no open batch")
+	}
+	current := s.batch
+	s.batch = nil
+	if current.failed != nil {
+		return Response{}, newCommandError(current.failed.Code, "batch rolled back, %s", current.failed.Message)
+	}
+	s.store.upsertLogs(current.logs)
+	results := []Result{}
+	for _, log := range current.logs {
+		results = append(results, Result{ID: log.ID})
+	}
+	return Response{Status: StatusOK, Results: results, text: []string{fmt.Sprintf("OK %d", len(current.logs))}}, nil
+}
+
+func (s *Session) processAbort() (Response, error) {
+	if s.batch == nil {
+		return Response{}, newCommandError(ErrBadState, "no open batch")
+	}
+	s.batch = nil
+	return Response{Status: StatusOK, text: []string{"OK"}}, nil
+}
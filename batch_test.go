@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSession_batch(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		commands []string
+		want     string
+	}{
+		{
+			"commit stores the batch",
+			3,
+			[]string{"BEGIN", "ADD 1 hello", "ADD 2 hello again", "SEARCH hello 5", "COMMIT", "SEARCH hello 5"},
+			"OK\r\nNONE\r\nOK 2\r\n2 1\r\n",
+		},
+		{
+			"abort discards the batch",
+			3,
+			[]string{"BEGIN", "ADD 1 hello", "ABORT", "SEARCH hello 5"},
+			"OK\r\nOK\r\nNONE\r\n",
+		},
+		{
+			"a bad line rolls the batch back",
+			3,
+			[]string{"BEGIN", "ADD 1 hello", "ADD 2", "ADD 3 hello", "COMMIT", "SEARCH hello 5"},
+			"OK\r\nERR BAD_ARGUMENT usage: ADD [key] [text]\r\n" +
+				"ERR BAD_ARGUMENT batch rolled back, line 2: usage: ADD [key] [text]\r\nNONE\r\n",
+		},
+		{
+			"eviction runs once for the whole batch",
+			2,
+			[]string{"ADD 1 hello", "BEGIN", "ADD 2 hello", "ADD 3 hello", "ADD 4 hello", "COMMIT", "SEARCH hello 5"},
+			"OK\r\nOK 3\r\n4 3\r\n",
+		},
+		{
+			"updates inside a batch",
+			3,
+			[]string{"ADD 1 hello", "BEGIN", "ADD 1 goodbye", "COMMIT", "SEARCH hello 5", "SEARCH goodbye 5"},
+			"OK\r\nOK 1\r\nNONE\r\n1\r\n",
+		},
+		{
+			"append inside a batch",
+			3,
+			[]string{"BEGIN", "ADD 1 hello", "APPEND hello", "COMMIT", "APPEND hello", "SEARCH hello 5"},
+			"OK\r\n2\r\nOK 2\r\n3\r\n3 2 1\r\n",
+		},
+		{
+			"nested begin",
+			3,
+			[]string{"BEGIN", "BEGIN"},
+			"OK\r\nERR BAD_STATE a batch is already open\r\n",
+		},
+		{
+			"commit without begin",
+			3,
+			[]string{"COMMIT", "ABORT"},
+			"ERR BAD_STATE no open batch\r\nERR BAD_STATE no open batch\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := &bytes.Buffer{}
+			session := getNewSession(getNewStore(tt.capacity), output)
+			for _, command := range tt.commands {
+				session.execute(command)
+			}
+			if got := output.String(); got != tt.want {
+				t.Errorf("execute() output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// checkIndex cross-validates both maps of index against the stored logs
+// and reports, as "[name] [kind] [key] [doc]" lines:
+//
+//	dangling    a posting list holds a doc that isn't a stored log
+//	unmirrored  a key and doc are in one of the two maps but not the other
+//	duplicate   a posting list holds the same doc twice
+//	missing     a log's data has the key, but the index doesn't map it
+//	stale       the index maps the key to a log whose data lacks it
+func (s *Storage) checkIndex(name string, index *InvertedIndex) []string {
+	issues := []string{}
+	report := func(kind, key string, doc DocID) {
+		issues = append(issues, fmt.Sprintf("%s %s %q %d", name, kind, key, doc))
+	}
+	stored := func(doc DocID) bool {
+		log, found := s.logsStorage[s.docs[doc]]
+		return found && log.DocID == doc
+	}
+	hasKey := func(doc DocID, key string) bool {
+		for _, other := range index.entryToKeys[doc] {
+			if other == key {
+				return true
+			}
+		}
+		return false
+	}
+
+	keys := make([]string, 0, len(index.keyToEntries))
+	for key := range index.keyToEntries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		seen := map[DocID]struct{}{}
+		for _, doc := range index.keyToEntries[key] {
+			if _, found := seen[doc]; found {
+				report("duplicate", key, doc)
+				continue
+			}
+			seen[doc] = struct{}{}
+			if !stored(doc) {
+				report("dangling", key, doc)
+			}
+			if !hasKey(doc, key) {
+				report("unmirrored", key, doc)
+			}
+		}
+	}
+	docs := []DocID{}
+	for doc := range index.entryToKeys {
+		docs = append(docs, doc)
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i] < docs[j] })
+	for _, doc := range docs {
+		found := stored(doc)
+		for _, key := range index.entryToKeys[doc] {
+			// Pairs also found in keyToEntries were reported above.
+			if containsDoc(index.keyToEntries[key], doc) {
+				continue
+			}
+			if !found {
+				report("dangling", key, doc)
+			}
+			report("unmirrored", key, doc)
+		}
+	}
+
+	ids := make([]string, 0, len(s.logsStorage))
+	for id := range s.logsStorage {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		log := s.logsStorage[LogID(id)]
+		want := map[string]struct{}{}
+		for _, key := range index.keys(&log) {
+			if _, found := want[key]; found {
+				continue
+			}
+			want[key] = struct{}{}
+			if !containsDoc(index.keyToEntries[key], log.DocID) && !hasKey(log.DocID, key) {
+				report("missing", key, log.DocID)
+			}
+		}
+		for _, key := range index.entryToKeys[log.DocID] {
+			if _, found := want[key]; !found {
+				report("stale", key, log.DocID)
+			}
+		}
+	}
+	return issues
+}
+
+// checkLogs validates the eviction buffer, the DocID map and the segment
+// locations against the stored logs, reporting "log [kind] [id]" lines:
+//
+//	unqueued    a stored log isn't in the buffer
+//	unstored    the buffer holds an id that isn't stored
+//	requeued    the buffer holds an id twice
+//	docmap      the DocID map and a log disagree on its doc
+//	misplaced   a flushed log's location doesn't point at it
+//	unexpiring  a stored log isn't in the expiry queue
+func (s *Storage) checkLogs() []string {
+	issues := []string{}
+	report := func(kind string, id LogID) {
+		issues = append(issues, fmt.Sprintf("log %s %q", kind, id))
+	}
+	queued := map[LogID]struct{}{}
+	s.buffer.Each(func(id *LogID) error {
+		if _, found := queued[*id]; found {
+			report("requeued", *id)
+			return nil
+		}
+		queued[*id] = struct{}{}
+		if _, err := s.getLogById(*id); err != nil {
+			report("unstored", *id)
+		}
+		return nil
+	})
+
+	ids := []string{}
+	for id := range s.logsStorage {
+		ids = append(ids, string(id))
+	}
+	if s.segments != nil {
+		for id := range s.segments.locations {
+			ids = append(ids, string(id))
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		log, err := s.getLogById(LogID(id))
+		if err != nil {
+			continue
+		}
+		if _, found := queued[LogID(id)]; !found {
+			report("unqueued", LogID(id))
+		}
+		if s.docs[log.DocID] != LogID(id) {
+			report("docmap", LogID(id))
+		}
+		if _, found := s.expiry.positions[LogID(id)]; !found {
+			report("unexpiring", LogID(id))
+		}
+		if ref, found := s.getFlushedRef(LogID(id)); found {
+			if ref.segment.data == nil || ref.segment.isDeleted(ref.ordinal) || log.ID != LogID(id) {
+				report("misplaced", LogID(id))
+			}
+		}
+	}
+	for doc, id := range s.docs {
+		if log, err := s.getLogById(id); err != nil || log.DocID != doc {
+			report("docmap", id)
+		}
+	}
+	return issues
+}
+
+// getFlushedRef returns the segment location of a log that only lives in a
+// segment.
+func (s *Storage) getFlushedRef(id LogID) (segmentRef, bool) {
+	if s.segments == nil {
+		return segmentRef{}, false
+	}
+	if _, found := s.logsStorage[id]; found {
+		return segmentRef{}, false
+	}
+	ref, found := s.segments.locations[id]
+	return ref, found
+}
+
+// check returns every inconsistency between the index, the stored logs and
+// the buffer. The history index isn't checked.
+func (s *Storage) check() []string {
+	issues := s.checkIndex("words", &s.index)
+	if s.index.grams != nil {
+		issues = append(issues, s.checkIndex("grams", s.index.grams)...)
+	}
+	return append(issues, s.checkLogs()...)
+}
+
+// rebuildIndex replaces the in-memory index with one built from the logs in
+// LogsStorage. Flushed logs are indexed by their segments.
+func (s *Storage) rebuildIndex() {
+	index := getNewIndex()
+	index.tokenize = s.index.tokenize
+	if s.index.grams != nil {
+		index.enableGrams()
+	}
+	for _, log := range s.logsStorage {
+		log := log
+		index.update(UpdateOpts{current: &log})
+	}
+	s.index = index
+}
+
+// processCheck lists the inconsistencies found, one per line, followed by
+// "OK [count]". With REPAIR the index is rebuilt and the count is of the
+// issues left afterwards, which only a broken buffer or DocID map leaves.
+func processCheck(store *Storage, arguments []string) (Response, error) {
+	repair := len(arguments) == 2 && strings.ToUpper(arguments[1]) == "REPAIR"
+	if len(arguments) > 2 || (len(arguments) == 2 && !repair) {
+		return Response{}, newCommandError(ErrBadArgument, "usage: CHECK [REPAIR]")
+	}
+	issues := store.check()
+	remaining := issues
+	if repair && len(issues) > 0 {
+		store.rebuildIndex()
+		remaining = store.check()
+	}
+	response := countResponse(len(remaining))
+	response.Data = map[string]interface{}{"issues": issues, "remaining": len(remaining), "repaired": repair}
+	response.text = append(append([]string{}, issues...), response.text...)
+	return response, nil
+}
+
+func containsDoc(docs []DocID, doc DocID) bool {
+	for _, other := range docs {
+		if other == doc {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func checkedStore() *Storage {
+	store := getNewStore(10)
+	store.enableTrigrams()
+	store.upsertLog("1", "disk full")
+	store.upsertLog("2", "disk ok")
+	return store
+}
+
+func TestStorage_check(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(s *Storage)
+		want    []string
+	}{
+		{"consistent", func(s *Storage) {
+			s.upsertLog("1", "disk almost full")
+			s.capacity = 2
+			s.upsertLog("3", "cpu hot")
+		}, []string{}},
+		{"dangling", func(s *Storage) {
+			s.index.updateEntry("ghost", 7)
+		}, []string{`words dangling "ghost" 7`}},
+		{"unmirrored", func(s *Storage) {
+			s.index.removeKeysFromEntry([]string{"full"}, 0)
+		}, []string{`words unmirrored "full" 0`}},
+		{"missing", func(s *Storage) {
+			s.index.removeKeysFromEntry([]string{"disk"}, 1)
+			s.index.removeEntryFromKeys([]string{"disk"}, 1)
+		}, []string{`words missing "disk" 1`}},
+		{"stale", func(s *Storage) {
+			s.index.updateEntry("cpu", 1)
+		}, []string{`words stale "cpu" 1`}},
+		{"duplicate", func(s *Storage) {
+			s.index.keyToEntries["ok"] = append(s.index.keyToEntries["ok"], 1)
+		}, []string{`words duplicate "ok" 1`}},
+		{"grams", func(s *Storage) {
+			s.index.grams.removeKeysFromEntry([]string{"ful"}, 0)
+			s.index.grams.removeEntryFromKeys([]string{"ful"}, 0)
+		}, []string{`grams missing "ful" 0`}},
+		{"unqueued", func(s *Storage) {
+			s.buffer.Dequeue()
+		}, []string{`log unqueued "1"`}},
+		{"requeued", func(s *Storage) {
+			id := LogID("2")
+			s.buffer.Enqueue(&id)
+		}, []string{`log requeued "2"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := checkedStore()
+			tt.corrupt(store)
+			if got := store.check(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("check() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStorage_checkSegments(t *testing.T) {
+	s := segmentedStore(t, 10, []string{"1", "2"})
+	s.upsertLog("3", "log 3")
+	if got := s.check(); len(got) != 0 {
+		t.Fatalf("check() = %q, want no issues", got)
+	}
+	ref := s.segments.locations["1"]
+	ref.segment.delete(ref.ordinal)
+	if got, want := s.check(), []string{`log misplaced "1"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("check() = %q, want %q", got, want)
+	}
+}
+
+func TestSession_processCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		corrupt  func(s *Storage)
+		commands []string
+		want     string
+	}{
+		{"consistent", func(s *Storage) {}, []string{"CHECK"}, "OK 0\r\n"},
+		{"found", func(s *Storage) {
+			s.index.updateEntry("cpu", 1)
+		}, []string{"CHECK", "SEARCH cpu 5"}, "words stale \"cpu\" 1\r\nOK 1\r\n2\r\n"},
+		{"repaired", func(s *Storage) {
+			s.index.updateEntry("cpu", 1)
+		}, []string{"CHECK REPAIR", "CHECK", "SEARCH cpu 5"}, "words stale \"cpu\" 1\r\nOK 0\r\nOK 0\r\nNONE\r\n"},
+		{"left after repair", func(s *Storage) {
+			s.buffer.Dequeue()
+		}, []string{"CHECK REPAIR"}, "log unqueued \"1\"\r\nOK 1\r\n"},
+		{"bad argument", func(s *Storage) {}, []string{"CHECK NOW"}, "ERR BAD_ARGUMENT usage: CHECK [REPAIR]\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := checkedStore()
+			tt.corrupt(store)
+			output := &bytes.Buffer{}
+			session := getNewSession(store, output)
+			for _, command := range tt.commands {
+				session.execute(command)
+			}
+			if got := output.String(); got != tt.want {
+				t.Errorf("execute() output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_runCLI_fsck(t *testing.T) {
+	store := checkedStore()
+	store.index.updateEntry("ghost", 7)
+	addr := startTestServer(t, store)
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantOutput string
+	}{
+		{"inconsistent", []string{"fsck", "--addr", addr}, exitFailure, "words dangling \"ghost\" 7\n"},
+		{"repair", []string{"fsck", "--addr", addr, "--repair"}, exitOK, "words dangling \"ghost\" 7\n"},
+		{"repaired", []string{"fsck", "--addr", addr}, exitOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			if code := runCLI(tt.args, nil, stdout, stderr); code != tt.wantCode {
+				t.Errorf("runCLI() = %d, want %d: %s", code, tt.wantCode, stderr)
+			}
+			if got := stdout.String(); got != tt.wantOutput {
+				t.Errorf("runCLI() output = %q, want %q", got, tt.wantOutput)
+			}
+		})
+	}
+}
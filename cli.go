@@ -0,0 +1,539 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exit codes returned by runCLI.
+const (
+	exitOK      = 0
+	exitNoMatch = 1
+	exitUsage   = 2
+	exitFailure = 3
+)
+
+const (
+	defaultCapacity = 10000
+	defaultAddr     = "localhost:7070"
+	rulesFile       = "rules.json"
+)
+
+type subcommand struct {
+	name    string
+	summary string
+	run     func(args []string, stdin io.Reader, stdout, stderr io.Writer) int
+}
+
+var subcommands []subcommand
+
+func init() {
+	subcommands = []subcommand{
+		{"run", "process a command file (or stdin) and print the responses", runCommand},
+		{"serve", "serve the command protocol over TCP", serveCommand},
+		{"ingest", "store each line of a file as a log, optionally following it", ingestCommand},
+		{"query", "search a plain log file for a word and print matching lines", queryCommand},
+		{"repl", "interactive command shell", replCommand},
+		{"export", "write the logs of a running server to a snapshot", exportCommand},
+		{"import", "load a snapshot into a running server", importCommand},
+		{"fsck", "check the index of a running server, optionally repairing it", fsckCommand},
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "usage: log-search <command> [flags]\n\ncommands:\n")
+	for _, cmd := range subcommands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nrun 'log-search <command> -h' for the flags of a command.\n")
+	fmt.Fprintf(w, "exit codes: %d ok, %d no match (query), %d usage error, %d failure\n",
+		exitOK, exitNoMatch, exitUsage, exitFailure)
+}
+
+func runCLI(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return exitUsage
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		usage(stdout)
+		return exitOK
+	}
+	for _, cmd := range subcommands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:], stdin, stdout, stderr)
+		}
+	}
+	fmt.Fprintf(stderr, "log-search: unknown command %q\n\n", args[0])
+	usage(stderr)
+	return exitUsage
+}
+
+type storeConfig struct {
+	capacity  int
+	ttl       time.Duration
+	tokenizer string
+	dataDir   string
+	alertSink string
+	history   int
+	trigrams  bool
+	segments  bool
+	flushSize int
+	flushAge  time.Duration
+	merge     string
+	mergeSize int
+	deleted   float64
+	compact   time.Duration
+	timestamp string
+	layouts   layoutFlag
+}
+
+func (c *storeConfig) register(flags *flag.FlagSet, defaultCapacity int) {
+	flags.IntVar(&c.capacity, "capacity", defaultCapacity, "maximum number of logs kept before the oldest are evicted")
+	flags.DurationVar(&c.ttl, "ttl", 0, "drop logs older than this, 0 keeps them until evicted")
+	flags.StringVar(&c.tokenizer, "tokenizer", "whitespace", "how logs are split into words: whitespace or standard")
+	flags.StringVar(&c.dataDir, "data-dir", "", "directory holding persisted state such as alert rules, repl history and segments, empty persists nothing")
+	flags.BoolVar(&c.trigrams, "trigrams", false, "keep a trigram index so *substring* searches don't scan every log")
+	flags.BoolVar(&c.segments, "segments", false, "move logs to on-disk segments under --data-dir instead of keeping them all in memory")
+	flags.IntVar(&c.flushSize, "flush-size", defaultFlushSize, "with --segments, fresh logs kept in memory before they are flushed")
+	flags.DurationVar(&c.flushAge, "flush-interval", defaultFlushInterval, "with --segments, longest time fresh logs wait for a flush")
+	flags.StringVar(&c.merge, "merge-policy", defaultMergePolicy, "with --segments, how segments are merged: tiered, deletes or none")
+	flags.IntVar(&c.mergeSize, "merge-factor", defaultMergeFactor, "with --segments, how many segments the tiered policy merges at once")
+	flags.Float64Var(&c.deleted, "max-deleted", defaultMaxDeleted, "with --segments, share of deleted logs above which a segment is rewritten")
+	flags.DurationVar(&c.compact, "compact-interval", defaultCompactInterval, "with --segments, how often serve looks for segments to merge, 0 disables")
+	flags.StringVar(&c.timestamp, "timestamps", "", "read the time of ingested logs from a timestamp they start with: auto or a comma-separated list of rfc3339, syslog, apache, nginx and epoch")
+	flags.Var(&c.layouts, "time-layout", "Go time layout of a timestamp ingested logs start with, may be repeated")
+	flags.IntVar(&c.history, "history", defaultHistoryLimit, "previous versions kept per updated log")
+	flags.StringVar(&c.alertSink, "alert-sink", "stderr", "where alert events go: stdout, stderr, file:<path> or a webhook url")
+}
+
+func newConfiguredStore(config storeConfig) (*Storage, error) {
+	if config.capacity <= 0 {
+		return nil, fmt.Errorf("capacity must be positive")
+	}
+	analyzer, err := getAnalyzer(config.tokenizer)
+	if err != nil {
+		return nil, err
+	}
+	store := getNewStore(config.capacity)
+	store.ttl = config.ttl
+	store.history.limit = config.history
+	store.setAnalyzer(analyzer)
+	if config.trigrams {
+		store.enableTrigrams()
+	}
+	if store.timestamps, err = getTimeExtractor(config.timestamp, config.layouts); err != nil {
+		return nil, err
+	}
+	if config.segments {
+		if config.dataDir == "" {
+			return nil, fmt.Errorf("--segments needs a --data-dir")
+		}
+		policy, err := getMergePolicy(config.merge)
+		if err != nil {
+			return nil, err
+		}
+		if err := store.enableSegments(filepath.Join(config.dataDir, segmentsDir), config.flushSize, config.flushAge); err != nil {
+			return nil, err
+		}
+		store.segments.policy = policy
+		store.segments.merge = mergeConfig{factor: config.mergeSize, maxDeleted: config.deleted}
+	}
+	if config.dataDir != "" {
+		if err := os.MkdirAll(config.dataDir, 0755); err != nil {
+			return nil, err
+		}
+		if err := store.alerts.load(filepath.Join(config.dataDir, rulesFile)); err != nil {
+			return nil, err
+		}
+	}
+	if store.alerts.sink, err = getSinkFromSpec(config.alertSink); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func newFlagSet(name string, stderr io.Writer, args string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: log-search %s [flags] %s\n\nflags:\n", name, args)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFlags returns the exit code to stop with, or -1 to carry on.
+func parseFlags(flags *flag.FlagSet, args []string) int {
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		return exitUsage
+	}
+	return -1
+}
+
+func openInput(path string, stdin io.Reader) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(stdin), nil
+	}
+	return os.Open(path)
+}
+
+// readLogLines calls handle with every non-empty line of the input.
+func readLogLines(path string, stdin io.Reader, handle func(line string)) error {
+	f, err := openInput(path, stdin)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line != "" {
+			handle(line)
+		}
+	}
+	return scanner.Err()
+}
+
+func fail(stderr io.Writer, err error) int {
+	fmt.Fprintf(stderr, "log-search: %v\n", err)
+	return exitFailure
+}
+
+func runCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var config storeConfig
+	var input string
+	flags := newFlagSet("run", stderr, "")
+	flags.StringVar(&input, "input", "-", "command file to process, - for stdin")
+	config.register(flags, 0)
+	if code := parseFlags(flags, args); code >= 0 {
+		return code
+	}
+	f, err := openInput(input, stdin)
+	if err != nil {
+		return fail(stderr, err)
+	}
+	defer f.Close()
+	if err := inputStreamDriver(f, stdout, config); err != nil {
+		return fail(stderr, err)
+	}
+	return exitOK
+}
+
+func serveCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var config storeConfig
+	var addrs listenAddrs
+	flags := newFlagSet("serve", stderr, "")
+	flags.StringVar(&addrs.commands, "addr", defaultAddr, "address to listen on")
+	flags.StringVar(&addrs.metrics, "metrics-addr", "", "address to serve Prometheus metrics on at /metrics, empty disables")
+	flags.StringVar(&addrs.syslogUDP, "syslog-udp", "", "address to receive syslog messages on over UDP, empty disables")
+	flags.StringVar(&addrs.syslogTCP, "syslog-tcp", "", "address to receive syslog messages on over TCP, empty disables")
+	config.register(flags, defaultCapacity)
+	if code := parseFlags(flags, args); code >= 0 {
+		return code
+	}
+	store, err := newConfiguredStore(config)
+	if err != nil {
+		return fail(stderr, err)
+	}
+	if err := serve(store, addrs, config.compact, stderr); err != nil {
+		return fail(stderr, err)
+	}
+	return exitOK
+}
+
+func ingestCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var addr, input, follow string
+	flags := newFlagSet("ingest", stderr, "")
+	flags.StringVar(&addr, "addr", defaultAddr, "address of the server")
+	flags.StringVar(&input, "input", "-", "log file to read once, - for stdin")
+	flags.StringVar(&follow, "follow", "", "log file to tail, handling rotation and truncation")
+	if code := parseFlags(flags, args); code >= 0 {
+		return code
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return fail(stderr, err)
+	}
+	defer conn.Close()
+
+	// Replies are read while lines are sent, so a big file doesn't wait for
+	// a round trip per line. done is closed once the server has replied to
+	// END or gone away, which also stops following.
+	done := make(chan struct{})
+	var replyErr error
+	go func() {
+		defer close(done)
+		replyErr = readIngestReplies(conn, stdout, stderr)
+	}()
+	writer := bufio.NewWriter(conn)
+	send := func(line string) {
+		writer.WriteString("APPEND " + line + "\r\n")
+	}
+	if follow != "" {
+		err = getNewTail(follow).follow(func(line string) {
+			send(line)
+			writer.Flush()
+		}, done)
+	} else {
+		err = readLogLines(input, stdin, send)
+	}
+	writer.WriteString("END\r\n")
+	if flushErr := writer.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		return fail(stderr, err)
+	}
+	<-done
+	if replyErr != nil {
+		return fail(stderr, replyErr)
+	}
+	return exitOK
+}
+
+// readIngestReplies prints the id of every appended log until the server
+// replies to END. Failed appends are reported and make it return an error.
+func readIngestReplies(conn io.Reader, stdout, stderr io.Writer) error {
+	replies := bufio.NewScanner(conn)
+	failed := 0
+	for replies.Scan() {
+		line := strings.TrimRight(replies.Text(), "\r")
+		switch {
+		case line == "END":
+			if failed > 0 {
+				return fmt.Errorf("%d lines not stored", failed)
+			}
+			return nil
+		case strings.HasPrefix(line, "ERR "):
+			fmt.Fprintln(stderr, line)
+			failed++
+		default:
+			fmt.Fprintf(stdout, "%s\r\n", line)
+		}
+	}
+	if err := replies.Err(); err != nil {
+		return err
+	}
+	return errors.New("connection closed before the ingest finished")
+}
+
+func queryCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var config storeConfig
+	var input string
+	flags := newFlagSet("query", stderr, "word [limit]")
+	flags.StringVar(&input, "input", "-", "log file to search, - for stdin")
+	config.register(flags, 0)
+	if code := parseFlags(flags, args); code >= 0 {
+		return code
+	}
+	// The whole file is searched unless a capacity is given.
+	if config.capacity == 0 {
+		config.capacity = math.MaxInt
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		flags.Usage()
+		return exitUsage
+	}
+	limit := config.capacity
+	if flags.NArg() == 2 {
+		var err error
+		if limit, err = strconv.Atoi(flags.Arg(1)); err != nil || limit < 0 {
+			fmt.Fprintf(stderr, "log-search: invalid limit %q\n", flags.Arg(1))
+			return exitUsage
+		}
+	}
+	store, err := newConfiguredStore(config)
+	if err != nil {
+		return fail(stderr, err)
+	}
+	if err := readLogLines(input, stdin, func(line string) { store.appendLog(line) }); err != nil {
+		return fail(stderr, err)
+	}
+	logs := store.getLogsByWord(flags.Arg(0), limit)
+	if len(logs) == 0 {
+		return exitNoMatch
+	}
+	for _, log := range logs {
+		fmt.Fprintln(stdout, log.Data)
+	}
+	return exitOK
+}
+
+func replCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var config storeConfig
+	flags := newFlagSet("repl", stderr, "")
+	config.register(flags, defaultCapacity)
+	if code := parseFlags(flags, args); code >= 0 {
+		return code
+	}
+	store, err := newConfiguredStore(config)
+	if err != nil {
+		return fail(stderr, err)
+	}
+	historyPath := ""
+	if config.dataDir != "" {
+		historyPath = filepath.Join(config.dataDir, historyFile)
+	}
+	if err := repl(store, stdin, stdout, historyPath); err != nil {
+		return fail(stderr, err)
+	}
+	return exitOK
+}
+
+func exportCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var addr, name, output string
+	flags := newFlagSet("export", stderr, "")
+	flags.StringVar(&addr, "addr", defaultAddr, "address of the server")
+	flags.StringVar(&name, "format", string(formatJSONL), "snapshot format: jsonl or csv")
+	flags.StringVar(&output, "output", "-", "snapshot file to write, - for stdout")
+	if code := parseFlags(flags, args); code >= 0 {
+		return code
+	}
+	format, err := getSnapshotFormat(name)
+	if err != nil {
+		fmt.Fprintf(stderr, "log-search: %v\n", err)
+		return exitUsage
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return fail(stderr, err)
+	}
+	defer conn.Close()
+	out := stdout
+	if output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			return fail(stderr, err)
+		}
+		defer f.Close()
+		out = f
+	}
+	writer := bufio.NewWriter(out)
+	fmt.Fprintf(conn, "EXPORT %s\r\nEND\r\n", format)
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(line, "ERR ") {
+			return fail(stderr, errors.New(line))
+		}
+		if strings.HasPrefix(line, "OK ") {
+			if err := writer.Flush(); err != nil {
+				return fail(stderr, err)
+			}
+			return exitOK
+		}
+		writer.WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return fail(stderr, err)
+	}
+	return fail(stderr, errors.New("connection closed before the export finished"))
+}
+
+func importCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var addr, name, input string
+	flags := newFlagSet("import", stderr, "")
+	flags.StringVar(&addr, "addr", defaultAddr, "address of the server")
+	flags.StringVar(&name, "format", string(formatJSONL), "snapshot format: jsonl or csv")
+	flags.StringVar(&input, "input", "-", "snapshot file to read, - for stdin")
+	if code := parseFlags(flags, args); code >= 0 {
+		return code
+	}
+	format, err := getSnapshotFormat(name)
+	if err != nil {
+		fmt.Fprintf(stderr, "log-search: %v\n", err)
+		return exitUsage
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return fail(stderr, err)
+	}
+	defer conn.Close()
+	replies := bufio.NewScanner(conn)
+	reply := func() string {
+		if !replies.Scan() {
+			return "ERR connection closed"
+		}
+		return strings.TrimRight(replies.Text(), "\r")
+	}
+
+	fmt.Fprintf(conn, "IMPORT %s\r\n", format)
+	if line := reply(); line != "OK" {
+		return fail(stderr, errors.New(line))
+	}
+	writer := bufio.NewWriter(conn)
+	err = readLogLines(input, stdin, func(line string) {
+		writer.WriteString(line + "\r\n")
+	})
+	writer.WriteString(importEnd + "\r\nEND\r\n")
+	if flushErr := writer.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		return fail(stderr, err)
+	}
+	line := reply()
+	if !strings.HasPrefix(line, "OK ") {
+		return fail(stderr, errors.New(line))
+	}
+	fmt.Fprintln(stdout, line)
+	return exitOK
+}
+
+func fsckCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var addr string
+	var repair bool
+	flags := newFlagSet("fsck", stderr, "")
+	flags.StringVar(&addr, "addr", defaultAddr, "address of the server")
+	flags.BoolVar(&repair, "repair", false, "rebuild the index from the stored logs when it is inconsistent")
+	if code := parseFlags(flags, args); code >= 0 {
+		return code
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return fail(stderr, err)
+	}
+	defer conn.Close()
+	command := "CHECK"
+	if repair {
+		command += " REPAIR"
+	}
+	fmt.Fprintf(conn, "%s\r\nEND\r\n", command)
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(line, "ERR ") {
+			return fail(stderr, errors.New(line))
+		}
+		if strings.HasPrefix(line, "OK ") {
+			if line != "OK 0" {
+				return fail(stderr, fmt.Errorf("%s inconsistencies left", strings.TrimPrefix(line, "OK ")))
+			}
+			return exitOK
+		}
+		fmt.Fprintln(stdout, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return fail(stderr, err)
+	}
+	return fail(stderr, errors.New("connection closed before the check finished"))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_runCLI(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		stdin      string
+		wantCode   int
+		wantStdout string
+	}{
+		{
+			"no arguments", nil, "", exitUsage, "",
+		},
+		{
+			"unknown command", []string{"frobnicate"}, "", exitUsage, "",
+		},
+		{
+			"unknown flag", []string{"run", "--nope"}, "", exitUsage, "",
+		},
+		{
+			"run from stdin",
+			[]string{"run", "--data-dir", ""},
+			"2\nADD 1 hello world\nSEARCH hello 1\nEND\n",
+			exitOK,
+			"1\r\nEND\r\n",
+		},
+		{
+			"run with capacity flag",
+			[]string{"run", "--data-dir", "", "--capacity", "1"},
+			"ADD 1 hello world\nADD 2 hello again\nSEARCH hello 2\nEND\n",
+			exitOK,
+			"2\r\nEND\r\n",
+		},
+		{
+			"run without END",
+			[]string{"run", "--data-dir", ""},
+			"2\nADD 1 hello world\n",
+			exitFailure,
+			"",
+		},
+		{
+			"unknown tokenizer",
+			[]string{"run", "--data-dir", "", "--tokenizer", "nope"},
+			"2\nEND\n",
+			exitFailure,
+			"",
+		},
+		{
+			"query by event time",
+			[]string{"query", "--data-dir", "", "--timestamps", "rfc3339", "disk"},
+			"2024-05-01T10:00:00Z disk a\n2024-05-01T12:00:00Z disk b\n2024-05-01T11:00:00Z disk c\n",
+			exitOK,
+			"2024-05-01T12:00:00Z disk b\n2024-05-01T11:00:00Z disk c\n2024-05-01T10:00:00Z disk a\n",
+		},
+		{
+			"unknown timestamp format",
+			[]string{"query", "--data-dir", "", "--timestamps", "iso", "disk"},
+			"",
+			exitFailure,
+			"",
+		},
+		{
+			"query with matches",
+			[]string{"query", "--data-dir", "", "--tokenizer", "standard", "error"},
+			"ERROR: disk full\nall good\nanother error\n",
+			exitOK,
+			"another error\nERROR: disk full\n",
+		},
+		{
+			"query with limit",
+			[]string{"query", "--data-dir", "", "error", "1"},
+			"error one\nerror two\n",
+			exitOK,
+			"error two\n",
+		},
+		{
+			"query searches the whole input",
+			[]string{"query", "--data-dir", "", "needle"},
+			"needle\n" + strings.Repeat("hay\n", defaultCapacity),
+			exitOK,
+			"needle\n",
+		},
+		{
+			"query without matches",
+			[]string{"query", "--data-dir", "", "error"},
+			"all good\n",
+			exitNoMatch,
+			"",
+		},
+		{
+			"query without word",
+			[]string{"query", "--data-dir", ""},
+			"",
+			exitUsage,
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout := &bytes.Buffer{}
+			stderr := &bytes.Buffer{}
+			code := runCLI(tt.args, strings.NewReader(tt.stdin), stdout, stderr)
+			if code != tt.wantCode {
+				t.Errorf("runCLI() = %d, want %d, stderr %q", code, tt.wantCode, stderr.String())
+			}
+			if got := stdout.String(); got != tt.wantStdout {
+				t.Errorf("runCLI() stdout = %q, want %q", got, tt.wantStdout)
+			}
+		})
+	}
+}
+
+func Test_runCLI_ingest(t *testing.T) {
+	store := getNewStore(10)
+	addr := startTestServer(t, store)
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	args := []string{"ingest", "--addr", addr}
+	if code := runCLI(args, strings.NewReader("disk full\n\ndisk ok\r\n"), stdout, stderr); code != exitOK {
+		t.Fatalf("ingest exited with %d: %s", code, stderr)
+	}
+	if got, want := stdout.String(), "1\r\n2\r\n"; got != want {
+		t.Errorf("ingest output = %q, want %q", got, want)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if got := store.getLogsByWord("disk", 5); len(got) != 2 || got[0].Data != "disk ok" {
+		t.Errorf("the server stored %v, want both lines", got)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMergePolicy     = "tiered"
+	defaultMergeFactor     = 4
+	defaultMaxDeleted      = 0.3
+	defaultCompactInterval = 10 * time.Second
+)
+
+// mergeConfig tunes the merge policy.
+type mergeConfig struct {
+	// factor is how many segments the tiered policy merges at once.
+	factor int
+	// maxDeleted is the share of deleted docs above which a segment is
+	// rewritten on its own.
+	maxDeleted float64
+}
+
+// mergePolicy picks the segments to merge next, or none when the segments
+// are fine as they are.
+type mergePolicy func(segments []*segment, config mergeConfig) []*segment
+
+var mergePolicies = map[string]mergePolicy{
+	"tiered":  mergeTiered,
+	"deletes": mergeDeletes,
+	"none":    func(segments []*segment, config mergeConfig) []*segment { return nil },
+}
+
+func getMergePolicy(name string) (mergePolicy, error) {
+	policy, found := mergePolicies[name]
+	if !found {
+		names := []string{}
+		for name := range mergePolicies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown merge policy %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	return policy, nil
+}
+
+// mergeTiered puts segments in tiers by their live count, a tier for each
+// power of factor, and merges the factor smallest segments of the lowest
+// tier that has that many. A merged segment moves up a tier instead of
+// being merged again with every few flushes, so each log is rewritten about
+// once per tier. Otherwise it rewrites segments with too many deletes.
+func mergeTiered(segments []*segment, config mergeConfig) []*segment {
+	if config.factor > 1 {
+		tiers := map[int][]*segment{}
+		lowest := -1
+		for _, seg := range segments {
+			tier := segmentTier(seg.live, config.factor)
+			tiers[tier] = append(tiers[tier], seg)
+			if len(tiers[tier]) >= config.factor && (lowest < 0 || tier < lowest) {
+				lowest = tier
+			}
+		}
+		if lowest >= 0 {
+			smallest := tiers[lowest]
+			sort.SliceStable(smallest, func(i, j int) bool {
+				return smallest[i].live < smallest[j].live
+			})
+			return smallest[:config.factor]
+		}
+	}
+	return mergeDeletes(segments, config)
+}
+
+// segmentTier is the largest tier such that factor to its power is at most
+// live, 0 for segments with fewer than factor live logs.
+func segmentTier(live, factor int) int {
+	tier := 0
+	for ; live >= factor; live /= factor {
+		tier++
+	}
+	return tier
+}
+
+// mergeDeletes rewrites the segment with the largest share of deleted docs
+// once that share passes maxDeleted.
+func mergeDeletes(segments []*segment, config mergeConfig) []*segment {
+	var worst *segment
+	worstShare := 0.0
+	for _, seg := range segments {
+		share := float64(seg.docs-seg.live) / float64(seg.docs)
+		if share > worstShare {
+			worst, worstShare = seg, share
+		}
+	}
+	if worst == nil || worstShare <= config.maxDeleted {
+		return nil
+	}
+	return []*segment{worst}
+}
+
+// compactionStats counts what compaction has done since the store started.
+type compactionStats struct {
+	Merges         int   `json:"merges"`
+	SegmentsMerged int   `json:"segments_merged"`
+	LogsDropped    int   `json:"logs_dropped"`
+	BytesReclaimed int64 `json:"bytes_reclaimed"`
+}
+
+// compaction merges some segments into a new one, leaving out the docs that
+// were deleted when it was planned.
+type compaction struct {
+	sources []*segment
+	path    string
+	// ordinals maps the docs of every source to their position in the new
+	// segment, -1 for the ones left out.
+	ordinals [][]int
+	logs     []Log
+	postings map[string][]uint32
+}
+
+// planCompaction asks the merge policy for work. all merges every segment
+// regardless of the policy. The store must be locked.
+func (s *Storage) planCompaction(all bool) *compaction {
+	if s.segments == nil {
+		return nil
+	}
+	idle := []*segment{}
+	for _, seg := range s.segments.list {
+		if !seg.merging {
+			idle = append(idle, seg)
+		}
+	}
+	var sources []*segment
+	if all {
+		if len(idle) > 1 || (len(idle) == 1 && idle[0].live < idle[0].docs) {
+			sources = idle
+		}
+	} else {
+		sources = s.segments.policy(idle, s.segments.merge)
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+	s.segments.seq++
+	c := &compaction{
+		sources: sources,
+		path:    filepath.Join(s.segments.dir, fmt.Sprintf("%06d%s", s.segments.seq, segmentExt)),
+	}
+	for _, seg := range sources {
+		seg.merging = true
+		ordinals := make([]int, seg.docs)
+		for ordinal := range ordinals {
+			ordinals[ordinal] = -1
+			if !seg.isDeleted(uint32(ordinal)) {
+				ordinals[ordinal] = len(c.logs)
+				c.logs = append(c.logs, Log{})
+			}
+		}
+		c.ordinals = append(c.ordinals, ordinals)
+	}
+	return c
+}
+
+// write reads the live docs of the sources and writes the new segment. It
+// only reads the immutable part of the sources, so the store doesn't need to
+// be locked.
+func (c *compaction) write() error {
+	if len(c.logs) == 0 {
+		return nil
+	}
+	c.postings = map[string][]uint32{}
+	for i, seg := range c.sources {
+		for ordinal, position := range c.ordinals[i] {
+			if position >= 0 {
+				c.logs[position] = seg.log(uint32(ordinal))
+			}
+		}
+		for term := 0; term < seg.terms; term++ {
+			key := seg.key(term)
+			for _, ordinal := range seg.ordinals(term) {
+				if position := c.ordinals[i][ordinal]; position >= 0 {
+					c.postings[key] = append(c.postings[key], uint32(position))
+				}
+			}
+		}
+	}
+	return writeSegmentPostings(c.path, c.logs, c.postings)
+}
+
+// finishCompaction swaps the new segment in for its sources, applying the
+// deletes that happened while it was written. When writing failed the
+// sources are kept. The store must be locked.
+func (s *Storage) finishCompaction(c *compaction, err error) error {
+	for _, seg := range c.sources {
+		seg.merging = false
+	}
+	var merged *segment
+	if err == nil && len(c.logs) > 0 {
+		merged, err = openSegment(c.path)
+	}
+	if err != nil {
+		os.Remove(c.path)
+		for _, seg := range c.sources {
+			if seg.live == 0 {
+				s.dropSegment(seg)
+			}
+		}
+		return err
+	}
+
+	stats := &s.segments.stats
+	for i, seg := range c.sources {
+		for ordinal, position := range c.ordinals[i] {
+			if position < 0 {
+				stats.LogsDropped++
+				continue
+			}
+			if seg.isDeleted(uint32(ordinal)) {
+				merged.delete(uint32(position))
+			} else {
+				s.segments.locations[c.logs[position].ID] = segmentRef{merged, uint32(position)}
+			}
+		}
+		stats.BytesReclaimed += int64(len(seg.data))
+	}
+	if merged != nil {
+		stats.BytesReclaimed -= int64(len(merged.data))
+	}
+	stats.Merges++
+	stats.SegmentsMerged += len(c.sources)
+
+	list := []*segment{}
+	for _, seg := range s.segments.list {
+		if seg == c.sources[0] && merged != nil {
+			list = append(list, merged)
+		}
+		if !c.merges(seg) {
+			list = append(list, seg)
+		}
+	}
+	s.segments.list = list
+	for _, seg := range c.sources {
+		seg.close()
+		os.Remove(seg.path)
+	}
+	if merged != nil && merged.live == 0 {
+		s.dropSegment(merged)
+	}
+	return nil
+}
+
+func (c *compaction) merges(seg *segment) bool {
+	for _, source := range c.sources {
+		if source == seg {
+			return true
+		}
+	}
+	return false
+}
+
+// compact runs merges until the policy is satisfied, or merges every
+// segment into one when all is set, and returns how many merges ran. The
+// store must be locked for the whole time.
+func (s *Storage) compact(all bool) (int, error) {
+	merges := 0
+	for c := s.planCompaction(all); c != nil; c = s.planCompaction(false) {
+		if err := s.finishCompaction(c, c.write()); err != nil {
+			return merges, err
+		}
+		merges++
+	}
+	return merges, nil
+}
+
+// compactInBackground runs a single merge picked by the policy. Unlike the
+// other Storage methods it takes the store lock itself, and only while
+// planning and swapping in the result, so ADD and SEARCH carry on while the
+// new segment is written.
+func compactInBackground(s *Storage) (bool, error) {
+	s.mu.Lock()
+	c := s.planCompaction(false)
+	s.mu.Unlock()
+	if c == nil {
+		return false, nil
+	}
+	err := c.write()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return true, s.finishCompaction(c, err)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_mergePolicies(t *testing.T) {
+	segments := func(sizes ...[2]int) []*segment {
+		list := []*segment{}
+		for _, size := range sizes {
+			list = append(list, &segment{docs: size[0], live: size[1]})
+		}
+		return list
+	}
+	config := mergeConfig{factor: 2, maxDeleted: 0.5}
+	tests := []struct {
+		name     string
+		policy   string
+		segments []*segment
+		want     []int
+	}{
+		{"tiered merges the smallest of a tier", "tiered", segments([2]int{10, 10}, [2]int{3, 3}, [2]int{4, 4}, [2]int{6, 2}), []int{3, 1}},
+		{"tiered leaves other tiers alone", "tiered", segments([2]int{10, 10}, [2]int{4, 4}), nil},
+		{"tiered waits for enough segments", "tiered", segments([2]int{10, 10}), nil},
+		{"tiered rewrites deleted segments", "tiered", segments([2]int{10, 2}), []int{0}},
+		{"deletes picks the worst", "deletes", segments([2]int{10, 4}, [2]int{10, 1}, [2]int{4, 4}), []int{1}},
+		{"deletes below the threshold", "deletes", segments([2]int{10, 6}), nil},
+		{"none", "none", segments([2]int{10, 1}, [2]int{4, 4}), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := getMergePolicy(tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []int
+			for _, picked := range policy(tt.segments, config) {
+				for i, seg := range tt.segments {
+					if seg == picked {
+						got = append(got, i)
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("policy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if _, err := getMergePolicy("nope"); err == nil {
+		t.Errorf("getMergePolicy() should reject unknown policies")
+	}
+}
+
+// segmentedStore returns a store with one segment per group of logs.
+func segmentedStore(t *testing.T, capacity int, groups ...[]string) *Storage {
+	s := getNewStore(capacity)
+	if err := s.enableSegments(t.TempDir(), 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	s.segments.policy = mergeTiered
+	s.segments.merge = mergeConfig{factor: 3, maxDeleted: 0.5}
+	for _, group := range groups {
+		for _, id := range group {
+			s.upsertLog(LogID(id), "log "+id)
+		}
+		if _, err := s.flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestStorage_compact(t *testing.T) {
+	s := segmentedStore(t, 10, []string{"1", "2"}, []string{"3", "4"}, []string{"5"})
+	s.upsertLog("2", "log 2 updated")
+	merges, err := s.compact(false)
+	if merges != 1 || err != nil {
+		t.Fatalf("compact() = %d, %v, want 1 merge", merges, err)
+	}
+	if len(s.segments.list) != 1 || s.segments.list[0].docs != 4 {
+		t.Fatalf("compact() should merge the live logs into one segment, got %d segments", len(s.segments.list))
+	}
+	if files, _ := filepath.Glob(filepath.Join(s.segments.dir, "*"+segmentExt)); len(files) != 1 {
+		t.Errorf("merged segment files left behind: %v", files)
+	}
+	want := compactionStats{Merges: 1, SegmentsMerged: 3, LogsDropped: 1}
+	got := s.segments.stats
+	if got.BytesReclaimed <= 0 {
+		t.Errorf("BytesReclaimed = %d, want more than 0", got.BytesReclaimed)
+	}
+	got.BytesReclaimed = 0
+	if got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+
+	for i := 1; i <= 5; i++ {
+		log, err := s.getLogById(LogID(fmt.Sprint(i)))
+		if err != nil || (i != 2 && log.Data != fmt.Sprintf("log %d", i)) {
+			t.Errorf("getLogById(%d) after compaction = %v, %v", i, log, err)
+		}
+	}
+	if got := s.getLogsByWord("log", 10); len(got) != 5 {
+		t.Errorf("search after compaction found %d logs, want 5", len(got))
+	}
+}
+
+func TestStorage_compactTiers(t *testing.T) {
+	s := segmentedStore(t, 100)
+	s.segments.merge.factor = 2
+	flush := func(id int) {
+		s.upsertLog(LogID(fmt.Sprint(id)), fmt.Sprintf("log %d", id))
+		if _, err := s.flush(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.compact(false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id := 1; id <= 8; id++ {
+		flush(id)
+	}
+	if len(s.segments.list) != 1 || s.segments.list[0].live != 8 {
+		t.Fatalf("compact() should merge 8 flushes into one segment, got %d segments", len(s.segments.list))
+	}
+	large := s.segments.list[0]
+	for id := 9; id <= 15; id++ {
+		flush(id)
+		if s.segments.list[0] != large {
+			t.Fatalf("flush %d rewrote the large segment", id)
+		}
+	}
+	if got := s.getLogsByWord("log", 20); len(got) != 15 {
+		t.Errorf("search after compaction found %d logs, want 15", len(got))
+	}
+}
+
+func TestStorage_compactInBackground(t *testing.T) {
+	s := segmentedStore(t, 10, []string{"1", "2"}, []string{"3"}, []string{"4"})
+	s.mu.Lock()
+	c := s.planCompaction(false)
+	s.mu.Unlock()
+	if c == nil {
+		t.Fatal("planCompaction() found nothing to merge")
+	}
+
+	err := c.write()
+	s.mu.Lock()
+	s.upsertLog("1", "log 1 updated")
+	s.deleteLogById("3")
+	s.mu.Unlock()
+	if err := s.finishCompaction(c, err); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := s.segments.list[0].live; got != 2 {
+		t.Errorf("merged segment has %d live logs, want 2", got)
+	}
+	if got := s.getLogsByWord("updated", 5); len(got) != 1 || got[0].ID != "1" {
+		t.Errorf("update during compaction lost: %v", got)
+	}
+	if _, err := s.getLogById("3"); err == nil {
+		t.Errorf("delete during compaction lost")
+	}
+	if merged, err := compactInBackground(s); merged || err != nil {
+		t.Errorf("compactInBackground() = %v, %v, want nothing to merge", merged, err)
+	}
+}
+
+func TestSession_processCompact(t *testing.T) {
+	tests := []struct {
+		name     string
+		segments bool
+		commands []string
+		want     string
+	}{
+		{"disabled", false, []string{"COMPACT"}, "ERR BAD_STATE segments are disabled\r\n"},
+		{"nothing to merge", true, []string{"ADD 1 a", "FLUSH", "COMPACT"}, "OK 1\r\nOK 0\r\n"},
+		{"all", true, []string{"ADD 1 a", "FLUSH", "ADD 2 b", "FLUSH", "COMPACT ALL", "SEARCH b 1"}, "OK 1\r\nOK 1\r\nOK 1\r\n2\r\n"},
+		{"bad argument", true, []string{"COMPACT NOW"}, "ERR BAD_ARGUMENT usage: COMPACT [ALL]\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := &bytes.Buffer{}
+			store := getNewStore(10)
+			if tt.segments {
+				store.enableSegments(t.TempDir(), 0, 0)
+			}
+			session := getNewSession(store, output)
+			for _, command := range tt.commands {
+				session.execute(command)
+			}
+			if got := output.String(); got != tt.want {
+				t.Errorf("execute() output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStorage_compactConcurrently(t *testing.T) {
+	s := segmentedStore(t, 50)
+	s.segments.merge = mergeConfig{factor: 2, maxDeleted: 0.2}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			if _, err := compactInBackground(s); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 300; i++ {
+		s.mu.Lock()
+		s.upsertLog(LogID(fmt.Sprint(i%80)), fmt.Sprintf("log %d", i))
+		if i%10 == 0 {
+			s.flush()
+		}
+		s.getLogsByWord("log", 5)
+		s.mu.Unlock()
+	}
+	<-done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if got := s.getLogsByWord("log", 100); len(got) != 50 {
+		t.Errorf("search found %d logs, want 50", len(got))
+	}
+	for id, ref := range s.segments.locations {
+		if log := ref.segment.log(ref.ordinal); log.ID != id || ref.segment.isDeleted(ref.ordinal) {
+			t.Errorf("location of %s points at %v", id, log)
+		}
+	}
+}
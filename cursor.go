@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// searchPosition is a point in the SEARCH result order, which is newest
+// first, with logs created at the same time in reverse order of arrival and
+// then by id.
+type searchPosition struct {
+	CreatedAt time.Time
+	Seq       uint64
+	ID        LogID
+}
+
+func getSearchPosition(log Log) searchPosition {
+	return searchPosition{CreatedAt: log.CreatedAt, Seq: log.Seq, ID: log.ID}
+}
+
+// before reports whether p comes earlier than other in the result order.
+func (p searchPosition) before(other searchPosition) bool {
+	if !p.CreatedAt.Equal(other.CreatedAt) {
+		return p.CreatedAt.After(other.CreatedAt)
+	}
+	if p.Seq != other.Seq {
+		return p.Seq > other.Seq
+	}
+	return p.ID > other.ID
+}
+
+// encodeCursor turns the position of the last result of a page into an
+// opaque token. Positions don't depend on what else is stored, so a cursor
+// stays valid while logs are added or evicted between pages.
+func encodeCursor(p searchPosition) string {
+	raw := strconv.FormatInt(p.CreatedAt.UnixNano(), 10) + ":" + strconv.FormatUint(p.Seq, 10) + ":" + string(p.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (searchPosition, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return searchPosition{}, fmt.Errorf("invalid cursor")
+	}
+	parts := strings.SplitN(string(raw), ":", 3)
+	if len(parts) != 3 {
+		return searchPosition{}, fmt.Errorf("invalid cursor")
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return searchPosition{}, fmt.Errorf("invalid cursor")
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return searchPosition{}, fmt.Errorf("invalid cursor")
+	}
+	return searchPosition{CreatedAt: time.Unix(0, nanos), Seq: seq, ID: LogID(parts[2])}, nil
+}
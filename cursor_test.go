@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_decodeCursor(t *testing.T) {
+	position := searchPosition{CreatedAt: time.Unix(0, 1650000000123456789), Seq: 7, ID: "trace:42"}
+	tests := []struct {
+		name    string
+		cursor  string
+		want    searchPosition
+		wantErr bool
+	}{
+		{"round trip", encodeCursor(position), position, false},
+		{"not base64", "!!!", searchPosition{}, true},
+		{"missing id", "MTIz", searchPosition{}, true},
+		{"bad timestamp", "YWJjOng", searchPosition{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCursor(tt.cursor)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeCursor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (!got.CreatedAt.Equal(tt.want.CreatedAt) || got.Seq != tt.want.Seq || got.ID != tt.want.ID) {
+				t.Errorf("decodeCursor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSession_searchPages(t *testing.T) {
+	tests := []struct {
+		name    string
+		between []string
+		want    [][]string
+	}{
+		{
+			"all pages",
+			nil,
+			[][]string{{"5", "4"}, {"3", "2"}, {"1"}, nil},
+		},
+		{
+			"new logs between pages are skipped",
+			[]string{"ADD 6 hello"},
+			[][]string{{"5", "4"}, {"3", "2"}, {"1"}, nil},
+		},
+		{
+			"evicted logs between pages",
+			[]string{"ADD 7 other", "ADD 8 other", "ADD 9 other"},
+			[][]string{{"5", "4"}, {"3"}, nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := &bytes.Buffer{}
+			session := getNewSession(getNewStore(6), output)
+			for _, id := range []string{"1", "2", "3", "4", "5"} {
+				session.execute("ADD " + id + " hello")
+				time.Sleep(time.Microsecond)
+			}
+			output.Reset()
+
+			var got [][]string
+			command := "SEARCH hello 2 PAGE"
+			for i := 0; i < 5; i++ {
+				output.Reset()
+				session.execute(command)
+				lines := strings.Split(strings.TrimSpace(output.String()), "\r\n")
+				if lines[0] == "NONE" {
+					got = append(got, nil)
+					break
+				}
+				got = append(got, strings.Fields(lines[0]))
+				command = "SEARCH hello 2 AFTER " + strings.TrimPrefix(lines[1], "CURSOR ")
+				for _, between := range tt.between {
+					session.execute(between)
+				}
+				tt.between = nil
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pages = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzDecodeCursor(f *testing.F) {
+	f.Add(encodeCursor(searchPosition{CreatedAt: time.Unix(0, 1650000000123456789), ID: "trace:42"}))
+	f.Add("MTIz")
+	f.Add("LTE6MDo")
+	f.Fuzz(func(t *testing.T, cursor string) {
+		position, err := decodeCursor(cursor)
+		if err != nil {
+			return
+		}
+		again, err := decodeCursor(encodeCursor(position))
+		if err != nil || !again.CreatedAt.Equal(position.CreatedAt) || again.Seq != position.Seq || again.ID != position.ID {
+			t.Errorf("cursor %q doesn't survive a round trip: %+v, %v", cursor, again, err)
+		}
+	})
+}
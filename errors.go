@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type ErrorCode string
+
+const (
+	ErrUnknownCommand ErrorCode = "UNKNOWN_COMMAND"
+	ErrBadArgument    ErrorCode = "BAD_ARGUMENT"
+	ErrNotFound       ErrorCode = "NOT_FOUND"
+	ErrBadState       ErrorCode = "BAD_STATE"
+	ErrInternal       ErrorCode = "INTERNAL"
+)
+
+// CommandError is returned for a protocol line that couldn't be executed.
+type CommandError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+func newCommandError(code ErrorCode, format string, args ...interface{}) *CommandError {
+	return &CommandError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("%s %s", e.Code, e.Message)
+}
+
+// formatError renders err as a single "ERR <code> <message>" response line.
+func formatError(err error) string {
+	var commandErr *CommandError
+	if !errors.As(err, &commandErr) {
+		commandErr = newCommandError(ErrInternal, "%v", err)
+	}
+	message := strings.NewReplacer("\r", " ", "\n", " ").Replace(commandErr.Message)
+	return fmt.Sprintf("ERR %s %s\r\n", commandErr.Code, message)
+}
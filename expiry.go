@@ -0,0 +1,81 @@
+package main
+
+import (
+	"container/heap"
+	"time"
+)
+
+// expiryEntry is the time a stored log was created at.
+type expiryEntry struct {
+	id  LogID
+	at  time.Time
+	seq uint64
+}
+
+// expiryQueue is a min-heap of the stored logs by CreatedAt, then Seq, so
+// logs expire by their own time even when they arrive out of order. It
+// tracks where each log is, so evicted logs leave it in O(log n).
+type expiryQueue struct {
+	entries   []expiryEntry
+	positions map[LogID]int
+}
+
+func getNewExpiryQueue() *expiryQueue {
+	return &expiryQueue{positions: map[LogID]int{}}
+}
+
+func (q *expiryQueue) Len() int {
+	return len(q.entries)
+}
+
+func (q *expiryQueue) Less(i, j int) bool {
+	a, b := q.entries[i], q.entries[j]
+	if !a.at.Equal(b.at) {
+		return a.at.Before(b.at)
+	}
+	return a.seq < b.seq
+}
+
+func (q *expiryQueue) Swap(i, j int) {
+	q.entries[i], q.entries[j] = q.entries[j], q.entries[i]
+	q.positions[q.entries[i].id] = i
+	q.positions[q.entries[j].id] = j
+}
+
+func (q *expiryQueue) Push(x interface{}) {
+	entry := x.(expiryEntry)
+	q.positions[entry.id] = len(q.entries)
+	q.entries = append(q.entries, entry)
+}
+
+func (q *expiryQueue) Pop() interface{} {
+	last := q.entries[len(q.entries)-1]
+	q.entries = q.entries[:len(q.entries)-1]
+	delete(q.positions, last.id)
+	return last
+}
+
+// set adds log or moves it to its current CreatedAt.
+func (q *expiryQueue) set(log Log) {
+	entry := expiryEntry{id: log.ID, at: log.CreatedAt, seq: log.Seq}
+	if i, found := q.positions[log.ID]; found {
+		q.entries[i] = entry
+		heap.Fix(q, i)
+		return
+	}
+	heap.Push(q, entry)
+}
+
+func (q *expiryQueue) remove(id LogID) {
+	if i, found := q.positions[id]; found {
+		heap.Remove(q, i)
+	}
+}
+
+// oldest returns the log created first.
+func (q *expiryQueue) oldest() (expiryEntry, bool) {
+	if len(q.entries) == 0 {
+		return expiryEntry{}, false
+	}
+	return q.entries[0], true
+}
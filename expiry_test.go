@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_expiryQueue(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	q := getNewExpiryQueue()
+	for i, log := range []Log{
+		{ID: "a", CreatedAt: start.Add(time.Minute)},
+		{ID: "b", CreatedAt: start},
+		{ID: "c", CreatedAt: start.Add(time.Hour)},
+		{ID: "d", CreatedAt: start},
+		{ID: "e", CreatedAt: start.Add(-time.Hour)},
+	} {
+		log.Seq = uint64(i)
+		q.set(log)
+	}
+	q.remove("e")
+	q.set(Log{ID: "a", CreatedAt: start.Add(2 * time.Hour)})
+	q.remove("missing")
+
+	got := []LogID{}
+	for {
+		oldest, found := q.oldest()
+		if !found {
+			break
+		}
+		got = append(got, oldest.id)
+		q.remove(oldest.id)
+	}
+	if want := []LogID{"b", "d", "c", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expiry order = %v, want %v", got, want)
+	}
+	if len(q.positions) != 0 {
+		t.Errorf("positions = %v, want none left", q.positions)
+	}
+}
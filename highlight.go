@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const ellipsis = "..."
+
+// Highlighter wraps every matched term with Pre and Post.
+type Highlighter struct {
+	Pre  string
+	Post string
+}
+
+var (
+	markerHighlighter = Highlighter{Pre: "[", Post: "]"}
+	ansiHighlighter   = Highlighter{Pre: "\x1b[1;31m", Post: "\x1b[0m"}
+)
+
+func getHighlighter(arguments []string) (Highlighter, error) {
+	if len(arguments) == 0 {
+		return Highlighter{}, fmt.Errorf("usage: SET HIGHLIGHT ansi|markers [pre] [post]")
+	}
+	switch strings.ToLower(arguments[0]) {
+	case "ansi":
+		if len(arguments) == 1 {
+			return ansiHighlighter, nil
+		}
+	case "markers":
+		if len(arguments) == 1 {
+			return markerHighlighter, nil
+		}
+		if len(arguments) == 3 {
+			return Highlighter{Pre: arguments[1], Post: arguments[2]}, nil
+		}
+	}
+	return Highlighter{}, fmt.Errorf("usage: SET HIGHLIGHT ansi|markers [pre] [post]")
+}
+
+// matchedTokens returns the tokens of data whose term is one of the query
+// terms, as found by the analyzer.
+func matchedTokens(analyzer Analyzer, data, query string) []Token {
+	terms := map[string]struct{}{}
+	for _, term := range analyzer.terms(query) {
+		terms[term] = struct{}{}
+	}
+	matches := []Token{}
+	for _, token := range analyzer(data) {
+		if _, found := terms[token.Term]; found {
+			matches = append(matches, token)
+		}
+	}
+	return matches
+}
+
+// highlight marks the matched tokens of data[from:to]. The offsets of the
+// tokens let normalised matches, like a lowercased "Error:", be marked in
+// the original text.
+func (h Highlighter) highlight(data string, matches []Token, from, to int) string {
+	var b strings.Builder
+	at := from
+	for _, token := range matches {
+		if token.Start < from || token.End > to {
+			continue
+		}
+		b.WriteString(data[at:token.Start])
+		b.WriteString(h.Pre)
+		b.WriteString(data[token.Start:token.End])
+		b.WriteString(h.Post)
+		at = token.End
+	}
+	b.WriteString(data[at:to])
+	return b.String()
+}
+
+// snippet returns about width bytes of data around the first match,
+// highlighted, with an ellipsis on each side that was cut. A width of zero
+// keeps the whole of data.
+func (h Highlighter) snippet(data string, matches []Token, width int) string {
+	if width <= 0 || len(data) <= width {
+		return h.highlight(data, matches, 0, len(data))
+	}
+	center := 0
+	if len(matches) > 0 {
+		center = (matches[0].Start + matches[0].End) / 2
+	}
+	from := center - width/2
+	if from < 0 {
+		from = 0
+	}
+	to := from + width
+	if to > len(data) {
+		to = len(data)
+		from = to - width
+	}
+	from, to = alignToTokens(data, matches, from, to)
+
+	text := h.highlight(data, matches, from, to)
+	if from > 0 {
+		text = ellipsis + text
+	}
+	if to < len(data) {
+		text += ellipsis
+	}
+	return text
+}
+
+// alignToTokens widens [from, to) so it doesn't split a matched token or a
+// multi-byte character.
+func alignToTokens(data string, matches []Token, from, to int) (int, int) {
+	for _, token := range matches {
+		if token.Start < from && token.End > from {
+			from = token.Start
+		}
+		if token.Start < to && token.End > to {
+			to = token.End
+		}
+	}
+	for from > 0 && !isRuneStart(data[from]) {
+		from--
+	}
+	for to < len(data) && !isRuneStart(data[to]) {
+		to++
+	}
+	return from, to
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func Test_analyzeStandard(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []Token
+	}{
+		{"empty", "", []Token{}},
+		{"punctuation", "ERROR: disk-full", []Token{{"error", 0, 5}, {"disk", 7, 11}, {"full", 12, 16}}},
+		{"multi-byte", "café au lait", []Token{{"café", 0, 5}, {"au", 6, 8}, {"lait", 9, 13}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := analyzeStandard(tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("analyzeStandard() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_analyzeWhitespace(t *testing.T) {
+	for _, data := range []string{"hello world", "hello   world", "", "helloworld", "  padded  "} {
+		if got, want := Analyzer(analyzeWhitespace).terms(data), getWordsFromData(data); !reflect.DeepEqual(got, want) {
+			t.Errorf("analyzeWhitespace(%q) terms = %v, want %v", data, got, want)
+		}
+	}
+}
+
+func TestHighlighter_snippet(t *testing.T) {
+	tests := []struct {
+		name     string
+		analyzer Analyzer
+		data     string
+		query    string
+		width    int
+		want     string
+	}{
+		{
+			"whole line",
+			analyzeWhitespace,
+			"disk full on host",
+			"full",
+			0,
+			"disk [full] on host",
+		},
+		{
+			"every occurrence",
+			analyzeWhitespace,
+			"retry retry done",
+			"retry",
+			0,
+			"[retry] [retry] done",
+		},
+		{
+			"normalised match keeps original text",
+			analyzeStandard,
+			"ERROR: Disk full",
+			"error",
+			0,
+			"[ERROR]: Disk full",
+		},
+		{
+			"substring of a token is not a match",
+			analyzeStandard,
+			"ReadTimeoutException raised",
+			"timeout",
+			0,
+			"ReadTimeoutException raised",
+		},
+		{
+			"snippet around the first hit",
+			analyzeWhitespace,
+			"aaaa bbbb cccc dddd panic eeee ffff gggg hhhh",
+			"panic",
+			15,
+			"...dddd [panic] eeee...",
+		},
+		{
+			"snippet at the start",
+			analyzeWhitespace,
+			"panic aaaa bbbb cccc dddd eeee",
+			"panic",
+			10,
+			"[panic] aaaa...",
+		},
+		{
+			"snippet widened to keep the match whole",
+			analyzeWhitespace,
+			"aaaa bbbb averyverylongmatch cccc",
+			"averyverylongmatch",
+			6,
+			"...[averyverylongmatch]...",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches := matchedTokens(tt.analyzer, tt.data, tt.query)
+			if got := markerHighlighter.snippet(tt.data, matches, tt.width); got != tt.want {
+				t.Errorf("snippet() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSession_searchHighlight(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		want     string
+	}{
+		{
+			"highlight",
+			[]string{"ADD 1 disk full", "SEARCH full 1 HIGHLIGHT"},
+			"1 disk [full]\r\n",
+		},
+		{
+			"custom markers",
+			[]string{"ADD 1 disk full", "SET HIGHLIGHT markers <em> </em>", "SEARCH full 1 HIGHLIGHT"},
+			"OK\r\n1 disk <em>full</em>\r\n",
+		},
+		{
+			"ansi",
+			[]string{"ADD 1 disk full", "SET HIGHLIGHT ansi", "SEARCH full 1 HIGHLIGHT"},
+			"OK\r\n1 disk \x1b[1;31mfull\x1b[0m\r\n",
+		},
+		{
+			"snippet",
+			[]string{"ADD 1 aaaa bbbb full cccc dddd", "SEARCH full 1 SNIPPET 8"},
+			"1 ...b [full] c...\r\n",
+		},
+		{
+			"invalid snippet width",
+			[]string{"SEARCH full 1 SNIPPET wide"},
+			"ERR BAD_ARGUMENT invalid snippet width \"wide\"\r\n",
+		},
+		{
+			"invalid highlight setting",
+			[]string{"SET HIGHLIGHT neon"},
+			"ERR BAD_ARGUMENT usage: SET HIGHLIGHT ansi|markers [pre] [post]\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := &bytes.Buffer{}
+			session := getNewSession(getNewStore(3), output)
+			for _, command := range tt.commands {
+				session.execute(command)
+			}
+			if got := output.String(); got != tt.want {
+				t.Errorf("execute() output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
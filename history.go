@@ -0,0 +1,58 @@
+package main
+
+import "strings"
+
+const defaultHistoryLimit = 10
+
+// versionHistory keeps the previous versions of updated logs, at most limit
+// per log, together with an index over the words of those versions.
+type versionHistory struct {
+	versions map[LogID][]Log
+	index    InvertedIndex
+	limit    int
+}
+
+func getNewVersionHistory(limit int) versionHistory {
+	return versionHistory{
+		versions: map[LogID][]Log{},
+		index:    getNewIndex(),
+		limit:    limit,
+	}
+}
+
+// record stores previous as the latest old version of its log, dropping the
+// oldest versions beyond the limit.
+func (h *versionHistory) record(previous Log) {
+	if h.limit <= 0 {
+		return
+	}
+	versions := h.versions[previous.ID]
+	before := h.combined(previous.DocID, versions)
+	versions = append(versions, previous)
+	if len(versions) > h.limit {
+		versions = versions[len(versions)-h.limit:]
+	}
+	h.versions[previous.ID] = versions
+	after := h.combined(previous.DocID, versions)
+	h.index.update(UpdateOpts{previous: &before, current: &after})
+}
+
+func (h *versionHistory) forget(log Log) {
+	delete(h.versions, log.ID)
+	h.index.deletedByLogId(log.DocID)
+}
+
+// get returns the old versions of a log, oldest first.
+func (h *versionHistory) get(id LogID) []Log {
+	return h.versions[id]
+}
+
+// combined is a pseudo log holding the words of every old version, which is
+// what the history index stores for a doc.
+func (h *versionHistory) combined(doc DocID, versions []Log) Log {
+	data := []string{}
+	for _, version := range versions {
+		data = append(data, version.Data)
+	}
+	return Log{DocID: doc, Data: strings.Join(data, " ")}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStorage_getHistory(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		data  []string
+		want  []string
+	}{
+		{"single version", 3, []string{"first"}, []string{"first"}},
+		{"updated log", 3, []string{"first", "second", "third"}, []string{"first", "second", "third"}},
+		{"bounded history", 2, []string{"first", "second", "third", "fourth"}, []string{"second", "third", "fourth"}},
+		{"history disabled", 0, []string{"first", "second"}, []string{"second"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := getNewStore(10)
+			s.history.limit = tt.limit
+			for _, data := range tt.data {
+				s.upsertLog("1", data)
+			}
+			versions, err := s.getHistory("1")
+			if err != nil {
+				t.Fatalf("getHistory() error = %v", err)
+			}
+			got := []string{}
+			for i, version := range versions {
+				got = append(got, version.Data)
+				if i > 0 && version.Version <= versions[i-1].Version {
+					t.Errorf("getHistory() versions not increasing: %d after %d", version.Version, versions[i-1].Version)
+				}
+				if version.UpdatedAt.Before(version.CreatedAt) {
+					t.Errorf("getHistory() updatedAt %v before createdAt %v", version.UpdatedAt, version.CreatedAt)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getHistory() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStorage_searchLogs_history(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		query string
+		opts  SearchOptions
+		want  []LogID
+	}{
+		{"current version only", 3, "panic", SearchOptions{}, []LogID{"2"}},
+		{"including history", 3, "panic", SearchOptions{IncludeHistory: true}, []LogID{"2", "1"}},
+		{"word in both versions", 3, "disk", SearchOptions{IncludeHistory: true}, []LogID{"1"}},
+		{"version dropped from history", 1, "panic", SearchOptions{IncludeHistory: true}, []LogID{"2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := getNewStore(10)
+			s.history.limit = tt.limit
+			s.upsertLog("1", "panic disk full")
+			s.upsertLog("1", "disk recovered")
+			s.upsertLog("1", "disk fine")
+			s.upsertLog("2", "panic again")
+			got := []LogID{}
+			for _, log := range s.searchLogs(tt.query, 10, tt.opts) {
+				got = append(got, log.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("searchLogs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStorage_history_eviction(t *testing.T) {
+	s := getNewStore(1)
+	s.upsertLog("1", "panic")
+	s.upsertLog("1", "calm")
+	s.upsertLog("2", "other")
+	if _, found := s.history.versions["1"]; found {
+		t.Errorf("history of evicted log kept")
+	}
+	if got := s.history.index.getByKey("panic"); len(got) != 0 {
+		t.Errorf("history index still holds evicted log: %v", got)
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
-import "strings"
+import (
+	"sort"
+	"strings"
+)
 
 type UpdateOpts struct {
 	current  *Log
@@ -8,46 +11,65 @@ type UpdateOpts struct {
 }
 
 type InvertedIndex struct {
-	keyToEntries map[string][]LogID
-	entryToKeys  map[LogID][]string
+	keyToEntries map[string][]DocID
+	entryToKeys  map[DocID][]string
+	tokenize     Tokenizer
+	// fields is whether the fields of logs are indexed next to their words.
+	fields bool
+	// grams is the optional trigram index over the same docs, nil unless
+	// enableGrams was called.
+	grams *InvertedIndex
 }
 
 func getNewIndex() InvertedIndex {
 	return InvertedIndex{
-		keyToEntries: map[string][]LogID{},
-		entryToKeys:  map[LogID][]string{},
+		keyToEntries: map[string][]DocID{},
+		entryToKeys:  map[DocID][]string{},
+		fields:       true,
 	}
 }
 
+// enableGrams starts maintaining a trigram index next to the word index.
+// It only covers docs indexed afterwards.
+func (i *InvertedIndex) enableGrams() {
+	grams := getNewIndex()
+	grams.tokenize = getTrigrams
+	grams.fields = false
+	i.grams = &grams
+}
+
 func (i *InvertedIndex) update(opts UpdateOpts) {
 	i.updateEntries(opts.current)
 	if opts.previous != nil {
 		i.removeMappings(opts.previous, opts.current)
 	}
+	if i.grams != nil {
+		i.grams.update(opts)
+	}
 }
 
 func (i *InvertedIndex) removeMappings(prev, current *Log) {
-	prevWords := getWordsFromData(prev.Data)
-	currWords := getWordsFromData(current.Data)
+	prevWords := i.keys(prev)
+	currWords := i.keys(current)
 	keysDelta := getWordsDelta(prevWords, currWords)
-	i.removeKeysFromEntry(keysDelta, prev.ID)
-	i.removeEntryFromKeys(keysDelta, prev.ID)
+	i.removeKeysFromEntry(keysDelta, prev.DocID)
+	i.removeEntryFromKeys(keysDelta, prev.DocID)
 }
 
 func (i *InvertedIndex) updateEntries(log *Log) {
 	if log == nil {
 		return
 	}
-	words := getWordsFromData(log.Data)
+	words := i.keys(log)
 	for _, word := range words {
-		i.updateEntry(word, log.ID)
+		i.updateEntry(word, log.DocID)
 	}
 }
 
-func (i *InvertedIndex) updateEntry(key string, id LogID) {
+func (i *InvertedIndex) updateEntry(key string, id DocID) {
 	_, found := i.keyToEntries[key]
 	if !found {
-		i.keyToEntries[key] = []LogID{}
+		i.keyToEntries[key] = []DocID{}
 	}
 
 	_, found = i.entryToKeys[id]
@@ -77,7 +99,7 @@ func (i *InvertedIndex) updateEntry(key string, id LogID) {
 	}
 }
 
-func (i *InvertedIndex) getByKey(key string) []LogID {
+func (i *InvertedIndex) getByKey(key string) []DocID {
 	entries, found := i.keyToEntries[key]
 	if !found {
 		return nil
@@ -85,7 +107,23 @@ func (i *InvertedIndex) getByKey(key string) []LogID {
 	return entries
 }
 
-func (i *InvertedIndex) deletedByLogId(id LogID) {
+// keysWithPrefix returns the sorted keys that start with prefix and still
+// have entries.
+func (i *InvertedIndex) keysWithPrefix(prefix string) []string {
+	keys := []string{}
+	for key, entries := range i.keyToEntries {
+		if len(entries) > 0 && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (i *InvertedIndex) deletedByLogId(id DocID) {
+	if i.grams != nil {
+		i.grams.deletedByLogId(id)
+	}
 	keys, found := i.entryToKeys[id]
 	if !found {
 		return
@@ -94,12 +132,12 @@ func (i *InvertedIndex) deletedByLogId(id LogID) {
 	i.removeEntryFromKeys(keys, id)
 }
 
-func (i *InvertedIndex) removeEntryFromKeys(keys []string, id LogID) {
+func (i *InvertedIndex) removeEntryFromKeys(keys []string, id DocID) {
 	for _, key := range keys {
 		if _, found := i.keyToEntries[key]; !found {
 			continue
 		}
-		filteredEntries := []LogID{}
+		filteredEntries := []DocID{}
 		for _, logId := range i.keyToEntries[key] {
 			if logId != id {
 				filteredEntries = append(filteredEntries, logId)
@@ -109,7 +147,7 @@ func (i *InvertedIndex) removeEntryFromKeys(keys []string, id LogID) {
 	}
 }
 
-func (i *InvertedIndex) removeKeysFromEntry(keysToBeRemoved []string, id LogID) {
+func (i *InvertedIndex) removeKeysFromEntry(keysToBeRemoved []string, id DocID) {
 	storedKeys, found := i.entryToKeys[id]
 	if !found {
 		return
@@ -130,6 +168,130 @@ func (i *InvertedIndex) removeKeysFromEntry(keysToBeRemoved []string, id LogID)
 	i.entryToKeys[id] = filteredKeys
 }
 
+// words splits data into index keys with the index tokenizer, falling back
+// to whitespace splitting when none is configured.
+func (i *InvertedIndex) words(data string) []string {
+	if i.tokenize == nil {
+		return getWordsFromData(data)
+	}
+	return i.tokenize(data)
+}
+
+// keys returns the index keys of log: the words of its data and, unless
+// this is a trigram index, its fields.
+func (i *InvertedIndex) keys(log *Log) []string {
+	keys := i.words(log.Data)
+	if !i.fields || len(log.Fields) == 0 {
+		return keys
+	}
+	names := make([]string, 0, len(log.Fields))
+	for name := range log.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		keys = append(keys, fieldKeyPrefix+name+"="+log.Fields[name])
+	}
+	return keys
+}
+
+// fieldKeyPrefix marks field keys, keeping them apart from the words.
+const fieldKeyPrefix = "\x01"
+
+// queryKeys returns the keys a query must all match: a "{name=value}" query
+// matches a field, any other is tokenized like data.
+func (i *InvertedIndex) queryKeys(query string) []string {
+	if key, ok := parseFieldQuery(query); ok {
+		return []string{key}
+	}
+	return i.words(query)
+}
+
+// parseFieldQuery returns the index key of a "{name=value}" query.
+func parseFieldQuery(query string) (string, bool) {
+	if len(query) < 4 || query[0] != '{' || query[len(query)-1] != '}' || strings.IndexByte(query, '=') < 2 {
+		return "", false
+	}
+	return fieldKeyPrefix + query[1:len(query)-1], true
+}
+
+// displayKey shows a field key the way it is queried.
+func displayKey(key string) string {
+	if strings.HasPrefix(key, fieldKeyPrefix) {
+		return "{" + key[len(fieldKeyPrefix):] + "}"
+	}
+	return key
+}
+
+// getByQuery returns the entries that contain every key of the query.
+func (i *InvertedIndex) getByQuery(query string) []DocID {
+	keys := i.queryKeys(query)
+	if len(keys) == 0 {
+		return nil
+	}
+	entries := i.getByKey(keys[0])
+	for _, key := range keys[1:] {
+		if len(entries) == 0 {
+			break
+		}
+		entries = intersectEntries(entries, i.getByKey(key))
+	}
+	return entries
+}
+
+// getBySubstring returns the entries holding every trigram of text. These
+// are candidates that still need checking against the text. The second
+// result is false when the trigram index can't narrow the search, because it
+// is disabled or text is shorter than a trigram.
+func (i *InvertedIndex) getBySubstring(text string) ([]DocID, bool) {
+	if i.grams == nil {
+		return nil, false
+	}
+	grams := getTrigrams(text)
+	if len(grams) == 0 {
+		return nil, false
+	}
+	entries := i.grams.getByKey(grams[0])
+	for _, gram := range grams[1:] {
+		if len(entries) == 0 {
+			break
+		}
+		entries = intersectEntries(entries, i.grams.getByKey(gram))
+	}
+	return entries, true
+}
+
+func intersectEntries(a, b []DocID) []DocID {
+	inB := map[DocID]struct{}{}
+	for _, id := range b {
+		inB[id] = struct{}{}
+	}
+	result := []DocID{}
+	for _, id := range a {
+		if _, found := inB[id]; found {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+func unionEntries(a, b []DocID) []DocID {
+	if len(b) == 0 {
+		return a
+	}
+	seen := map[DocID]struct{}{}
+	result := []DocID{}
+	for _, entries := range [][]DocID{a, b} {
+		for _, id := range entries {
+			if _, found := seen[id]; !found {
+				seen[id] = struct{}{}
+				result = append(result, id)
+			}
+		}
+	}
+	return result
+}
+
 func getWordsFromData(data string) []string {
 	tokens := strings.Split(data, " ")
 	words := []string{}
@@ -7,8 +7,8 @@ import (
 
 func TestInvertedIndex_update(t *testing.T) {
 	type fields struct {
-		keyToEntries map[string][]LogID
-		entryToKeys  map[LogID][]string
+		keyToEntries map[string][]DocID
+		entryToKeys  map[DocID][]string
 	}
 	type args struct {
 		opts UpdateOpts
@@ -21,23 +21,23 @@ func TestInvertedIndex_update(t *testing.T) {
 		{
 			"prev is nil",
 			fields{
-				keyToEntries: map[string][]LogID{},
-				entryToKeys:  map[LogID][]string{},
+				keyToEntries: map[string][]DocID{},
+				entryToKeys:  map[DocID][]string{},
 			},
 			args{opts: UpdateOpts{
 				previous: nil,
-				current:  &Log{ID: 123, Data: "hello world"},
+				current:  &Log{DocID: 123, Data: "hello world"},
 			}},
 		},
 		{
 			"prev is not nil",
 			fields{
-				keyToEntries: map[string][]LogID{},
-				entryToKeys:  map[LogID][]string{},
+				keyToEntries: map[string][]DocID{},
+				entryToKeys:  map[DocID][]string{},
 			},
 			args{opts: UpdateOpts{
-				previous: &Log{ID: 123, Data: "hello world"},
-				current:  &Log{ID: 123, Data: "hello again"},
+				previous: &Log{DocID: 123, Data: "hello world"},
+				current:  &Log{DocID: 123, Data: "hello again"},
 			}},
 		},
 	}
@@ -54,8 +54,8 @@ func TestInvertedIndex_update(t *testing.T) {
 
 func TestInvertedIndex_updateEntries(t *testing.T) {
 	type fields struct {
-		keyToEntries map[string][]LogID
-		entryToKeys  map[LogID][]string
+		keyToEntries map[string][]DocID
+		entryToKeys  map[DocID][]string
 	}
 	type args struct {
 		log *Log
@@ -73,12 +73,12 @@ func TestInvertedIndex_updateEntries(t *testing.T) {
 		{
 			"log is not nil",
 			fields{
-				keyToEntries: map[string][]LogID{},
-				entryToKeys:  map[LogID][]string{},
+				keyToEntries: map[string][]DocID{},
+				entryToKeys:  map[DocID][]string{},
 			},
 			args{log: &Log{
-				ID:   123,
-				Data: "hello world",
+				DocID: 123,
+				Data:  "hello world",
 			}},
 		},
 	}
@@ -95,8 +95,8 @@ func TestInvertedIndex_updateEntries(t *testing.T) {
 
 func TestInvertedIndex_removeMappings(t *testing.T) {
 	type fields struct {
-		keyToEntries map[string][]LogID
-		entryToKeys  map[LogID][]string
+		keyToEntries map[string][]DocID
+		entryToKeys  map[DocID][]string
 	}
 	type args struct {
 		prev    *Log
@@ -111,64 +111,64 @@ func TestInvertedIndex_removeMappings(t *testing.T) {
 		{
 			"no update",
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {123}, "world": {123}},
-				entryToKeys:  map[LogID][]string{123: {"hello", "world"}},
+				keyToEntries: map[string][]DocID{"hello": {123}, "world": {123}},
+				entryToKeys:  map[DocID][]string{123: {"hello", "world"}},
 			},
 			args{
 				prev: &Log{
-					ID:   123,
-					Data: "hello world",
+					DocID: 123,
+					Data:  "hello world",
 				},
 				current: &Log{
-					ID:   123,
-					Data: "hello world",
+					DocID: 123,
+					Data:  "hello world",
 				},
 			},
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {123}, "world": {123}},
-				entryToKeys:  map[LogID][]string{123: {"hello", "world"}},
+				keyToEntries: map[string][]DocID{"hello": {123}, "world": {123}},
+				entryToKeys:  map[DocID][]string{123: {"hello", "world"}},
 			},
 		},
 		{
 			"complete update",
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {123}, "world": {123}},
-				entryToKeys:  map[LogID][]string{123: {"hello", "world"}},
+				keyToEntries: map[string][]DocID{"hello": {123}, "world": {123}},
+				entryToKeys:  map[DocID][]string{123: {"hello", "world"}},
 			},
 			args{
 				prev: &Log{
-					ID:   123,
-					Data: "hello world",
+					DocID: 123,
+					Data:  "hello world",
 				},
 				current: &Log{
-					ID:   123,
-					Data: "something else",
+					DocID: 123,
+					Data:  "something else",
 				},
 			},
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {}, "world": {}},
-				entryToKeys:  map[LogID][]string{123: {}},
+				keyToEntries: map[string][]DocID{"hello": {}, "world": {}},
+				entryToKeys:  map[DocID][]string{123: {}},
 			},
 		},
 		{
 			"partial update",
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {123}, "world": {123}},
-				entryToKeys:  map[LogID][]string{123: {"hello", "world"}},
+				keyToEntries: map[string][]DocID{"hello": {123}, "world": {123}},
+				entryToKeys:  map[DocID][]string{123: {"hello", "world"}},
 			},
 			args{
 				prev: &Log{
-					ID:   123,
-					Data: "hello world",
+					DocID: 123,
+					Data:  "hello world",
 				},
 				current: &Log{
-					ID:   123,
-					Data: "hello again",
+					DocID: 123,
+					Data:  "hello again",
 				},
 			},
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {123}, "world": {}},
-				entryToKeys:  map[LogID][]string{123: {"hello"}},
+				keyToEntries: map[string][]DocID{"hello": {123}, "world": {}},
+				entryToKeys:  map[DocID][]string{123: {"hello"}},
 			},
 		},
 	}
@@ -191,11 +191,11 @@ func TestInvertedIndex_removeMappings(t *testing.T) {
 
 func TestInvertedIndex_deletedByLogId(t *testing.T) {
 	type fields struct {
-		keyToEntries map[string][]LogID
-		entryToKeys  map[LogID][]string
+		keyToEntries map[string][]DocID
+		entryToKeys  map[DocID][]string
 	}
 	type args struct {
-		id LogID
+		id DocID
 	}
 	tests := []struct {
 		name   string
@@ -206,25 +206,25 @@ func TestInvertedIndex_deletedByLogId(t *testing.T) {
 		{
 			"log exists",
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {123}, "world": {123}},
-				entryToKeys:  map[LogID][]string{123: {"hello", "world"}},
+				keyToEntries: map[string][]DocID{"hello": {123}, "world": {123}},
+				entryToKeys:  map[DocID][]string{123: {"hello", "world"}},
 			},
 			args{id: 123},
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {}, "world": {}},
-				entryToKeys:  map[LogID][]string{},
+				keyToEntries: map[string][]DocID{"hello": {}, "world": {}},
+				entryToKeys:  map[DocID][]string{},
 			},
 		},
 		{
 			"log doesn't exist",
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {123}, "world": {123}},
-				entryToKeys:  map[LogID][]string{123: {"hello", "world"}},
+				keyToEntries: map[string][]DocID{"hello": {123}, "world": {123}},
+				entryToKeys:  map[DocID][]string{123: {"hello", "world"}},
 			},
 			args{id: 456},
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {123}, "world": {123}},
-				entryToKeys:  map[LogID][]string{123: {"hello", "world"}},
+				keyToEntries: map[string][]DocID{"hello": {123}, "world": {123}},
+				entryToKeys:  map[DocID][]string{123: {"hello", "world"}},
 			},
 		},
 	}
@@ -248,8 +248,8 @@ func TestInvertedIndex_deletedByLogId(t *testing.T) {
 
 func TestInvertedIndex_getByKey(t *testing.T) {
 	type fields struct {
-		keyToEntries map[string][]LogID
-		entryToKeys  map[LogID][]string
+		keyToEntries map[string][]DocID
+		entryToKeys  map[DocID][]string
 	}
 	type args struct {
 		key string
@@ -258,13 +258,13 @@ func TestInvertedIndex_getByKey(t *testing.T) {
 		name   string
 		fields fields
 		args   args
-		want   []LogID
+		want   []DocID
 	}{
 		{
 			"no entry exists",
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {123}, "world": {123}},
-				entryToKeys:  map[LogID][]string{123: {"hello", "world"}},
+				keyToEntries: map[string][]DocID{"hello": {123}, "world": {123}},
+				entryToKeys:  map[DocID][]string{123: {"hello", "world"}},
 			},
 			args{
 				key: "something",
@@ -274,24 +274,24 @@ func TestInvertedIndex_getByKey(t *testing.T) {
 		{
 			"single entry exists",
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {123}, "world": {123}},
-				entryToKeys:  map[LogID][]string{123: {"hello", "world"}},
+				keyToEntries: map[string][]DocID{"hello": {123}, "world": {123}},
+				entryToKeys:  map[DocID][]string{123: {"hello", "world"}},
 			},
 			args{
 				key: "hello",
 			},
-			[]LogID{123},
+			[]DocID{123},
 		},
 		{
 			"multiple entries exist",
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {123, 345, 567}, "world": {123}},
-				entryToKeys:  map[LogID][]string{123: {"hello", "world"}, 345: {"hello"}, 567: {"hello"}},
+				keyToEntries: map[string][]DocID{"hello": {123, 345, 567}, "world": {123}},
+				entryToKeys:  map[DocID][]string{123: {"hello", "world"}, 345: {"hello"}, 567: {"hello"}},
 			},
 			args{
 				key: "hello",
 			},
-			[]LogID{123, 345, 567},
+			[]DocID{123, 345, 567},
 		},
 	}
 	for _, tt := range tests {
@@ -309,11 +309,11 @@ func TestInvertedIndex_getByKey(t *testing.T) {
 
 func TestInvertedIndex_removeKeysFromEntry(t *testing.T) {
 	type fields struct {
-		entryToKeys map[LogID][]string
+		entryToKeys map[DocID][]string
 	}
 	type args struct {
 		keysToBeRemoved []string
-		id              LogID
+		id              DocID
 	}
 	tests := []struct {
 		name   string
@@ -324,40 +324,40 @@ func TestInvertedIndex_removeKeysFromEntry(t *testing.T) {
 		{
 			"entry doesn't exist",
 			fields{
-				entryToKeys: map[LogID][]string{123: {"hello", "world"}, 345: {"hello"}, 567: {"hello"}},
+				entryToKeys: map[DocID][]string{123: {"hello", "world"}, 345: {"hello"}, 567: {"hello"}},
 			},
 			args{
 				keysToBeRemoved: []string{"hello"},
 				id:              789,
 			},
 			fields{
-				entryToKeys: map[LogID][]string{123: {"hello", "world"}, 345: {"hello"}, 567: {"hello"}},
+				entryToKeys: map[DocID][]string{123: {"hello", "world"}, 345: {"hello"}, 567: {"hello"}},
 			},
 		},
 		{
 			"entry exists",
 			fields{
-				entryToKeys: map[LogID][]string{123: {"hello", "world"}, 345: {"hello"}, 567: {"hello"}},
+				entryToKeys: map[DocID][]string{123: {"hello", "world"}, 345: {"hello"}, 567: {"hello"}},
 			},
 			args{
 				keysToBeRemoved: []string{"hello"},
 				id:              123,
 			},
 			fields{
-				entryToKeys: map[LogID][]string{123: {"world"}, 345: {"hello"}, 567: {"hello"}},
+				entryToKeys: map[DocID][]string{123: {"world"}, 345: {"hello"}, 567: {"hello"}},
 			},
 		},
 		{
 			"entry exists but key in it doesn't",
 			fields{
-				entryToKeys: map[LogID][]string{123: {"world"}, 345: {"hello"}, 567: {"hello"}},
+				entryToKeys: map[DocID][]string{123: {"world"}, 345: {"hello"}, 567: {"hello"}},
 			},
 			args{
 				keysToBeRemoved: []string{"hello"},
 				id:              123,
 			},
 			fields{
-				entryToKeys: map[LogID][]string{123: {"world"}, 345: {"hello"}, 567: {"hello"}},
+				entryToKeys: map[DocID][]string{123: {"world"}, 345: {"hello"}, 567: {"hello"}},
 			},
 		},
 	}
@@ -376,11 +376,11 @@ func TestInvertedIndex_removeKeysFromEntry(t *testing.T) {
 
 func TestInvertedIndex_removeEntryFromKeys(t *testing.T) {
 	type fields struct {
-		keyToEntries map[string][]LogID
+		keyToEntries map[string][]DocID
 	}
 	type args struct {
 		keys []string
-		id   LogID
+		id   DocID
 	}
 	tests := []struct {
 		name   string
@@ -391,40 +391,40 @@ func TestInvertedIndex_removeEntryFromKeys(t *testing.T) {
 		{
 			"key doesn't exist",
 			fields{
-				keyToEntries: map[string][]LogID{"world": {123}},
+				keyToEntries: map[string][]DocID{"world": {123}},
 			},
 			args{
 				keys: []string{"hello"},
 				id:   789,
 			},
 			fields{
-				keyToEntries: map[string][]LogID{"world": {123}},
+				keyToEntries: map[string][]DocID{"world": {123}},
 			},
 		},
 		{
 			"key exists",
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {123, 345, 567}, "world": {123}},
+				keyToEntries: map[string][]DocID{"hello": {123, 345, 567}, "world": {123}},
 			},
 			args{
 				keys: []string{"hello"},
 				id:   123,
 			},
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {345, 567}, "world": {123}},
+				keyToEntries: map[string][]DocID{"hello": {345, 567}, "world": {123}},
 			},
 		},
 		{
 			"keys exists but entry in it doesn't",
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {345, 567}, "world": {123}},
+				keyToEntries: map[string][]DocID{"hello": {345, 567}, "world": {123}},
 			},
 			args{
 				keys: []string{"hello"},
 				id:   123,
 			},
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {345, 567}, "world": {123}},
+				keyToEntries: map[string][]DocID{"hello": {345, 567}, "world": {123}},
 			},
 		},
 	}
@@ -443,12 +443,12 @@ func TestInvertedIndex_removeEntryFromKeys(t *testing.T) {
 
 func TestInvertedIndex_updateEntry(t *testing.T) {
 	type fields struct {
-		keyToEntries map[string][]LogID
-		entryToKeys  map[LogID][]string
+		keyToEntries map[string][]DocID
+		entryToKeys  map[DocID][]string
 	}
 	type args struct {
 		key string
-		id  LogID
+		id  DocID
 	}
 	tests := []struct {
 		name   string
@@ -459,61 +459,61 @@ func TestInvertedIndex_updateEntry(t *testing.T) {
 		{
 			"no prior key or entry",
 			fields{
-				keyToEntries: map[string][]LogID{},
-				entryToKeys:  map[LogID][]string{},
+				keyToEntries: map[string][]DocID{},
+				entryToKeys:  map[DocID][]string{},
 			},
 			args{
 				key: "hello",
 				id:  123,
 			},
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {123}},
-				entryToKeys:  map[LogID][]string{123: {"hello"}},
+				keyToEntries: map[string][]DocID{"hello": {123}},
+				entryToKeys:  map[DocID][]string{123: {"hello"}},
 			},
 		},
 		{
 			"existing entry, no prior key",
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {123}},
-				entryToKeys:  map[LogID][]string{123: {"hello"}},
+				keyToEntries: map[string][]DocID{"hello": {123}},
+				entryToKeys:  map[DocID][]string{123: {"hello"}},
 			},
 			args{
 				key: "world",
 				id:  123,
 			},
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {123}, "world": {123}},
-				entryToKeys:  map[LogID][]string{123: {"hello", "world"}},
+				keyToEntries: map[string][]DocID{"hello": {123}, "world": {123}},
+				entryToKeys:  map[DocID][]string{123: {"hello", "world"}},
 			},
 		},
 		{
 			"existing key, no prior entry",
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {123}},
-				entryToKeys:  map[LogID][]string{123: {"hello"}},
+				keyToEntries: map[string][]DocID{"hello": {123}},
+				entryToKeys:  map[DocID][]string{123: {"hello"}},
 			},
 			args{
 				key: "hello",
 				id:  456,
 			},
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {123, 456}},
-				entryToKeys:  map[LogID][]string{123: {"hello"}, 456: {"hello"}},
+				keyToEntries: map[string][]DocID{"hello": {123, 456}},
+				entryToKeys:  map[DocID][]string{123: {"hello"}, 456: {"hello"}},
 			},
 		},
 		{
 			"existing key and entry",
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {123}},
-				entryToKeys:  map[LogID][]string{123: {"hello"}},
+				keyToEntries: map[string][]DocID{"hello": {123}},
+				entryToKeys:  map[DocID][]string{123: {"hello"}},
 			},
 			args{
 				key: "hello",
 				id:  123,
 			},
 			fields{
-				keyToEntries: map[string][]LogID{"hello": {123}},
-				entryToKeys:  map[LogID][]string{123: {"hello"}},
+				keyToEntries: map[string][]DocID{"hello": {123}},
+				entryToKeys:  map[DocID][]string{123: {"hello"}},
 			},
 		},
 	}
@@ -648,3 +648,42 @@ func Test_getWordsFromData(t *testing.T) {
 		})
 	}
 }
+
+func Test_parseFieldQuery(t *testing.T) {
+	tests := []struct {
+		query  string
+		want   string
+		wantOK bool
+	}{
+		{"{host=web1}", fieldKeyPrefix + "host=web1", true},
+		{"{msg=a=b}", fieldKeyPrefix + "msg=a=b", true},
+		{"{host=}", fieldKeyPrefix + "host=", true},
+		{"{=web1}", "", false},
+		{"{host}", "", false},
+		{"host=web1", "", false},
+		{"{}", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, ok := parseFieldQuery(tt.query)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("parseFieldQuery() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestInvertedIndex_fields(t *testing.T) {
+	index := getNewIndex()
+	log := &Log{ID: "1", DocID: 1, Data: "disk full", Fields: map[string]string{"host": "web1"}}
+	index.update(UpdateOpts{current: log})
+	if got := index.keys(log); !reflect.DeepEqual(got, []string{"disk", "full", fieldKeyPrefix + "host=web1"}) {
+		t.Errorf("keys() = %q", got)
+	}
+	if got := index.getByKey(fieldKeyPrefix + "host=web1"); len(got) != 1 {
+		t.Errorf("getByKey() of a field = %v, want the log", got)
+	}
+	if got := displayKey(fieldKeyPrefix + "host=web1"); got != "{host=web1}" {
+		t.Errorf("displayKey() = %q", got)
+	}
+}
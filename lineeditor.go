@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var errInterrupted = errors.New("interrupted")
+
+const (
+	keyCtrlA     = 1
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlH     = 8
+	keyTab       = 9
+	keyLineFeed  = 10
+	keyEnter     = 13
+	keyCtrlU     = 21
+	keyEscape    = 27
+	keyBackspace = 127
+)
+
+// lineEditor reads lines from a terminal in raw mode, with cursor movement,
+// history navigation and tab completion.
+type lineEditor struct {
+	in         *bufio.Reader
+	out        io.Writer
+	prompt     string
+	history    []string
+	maxHistory int
+	complete   func(line string) []string
+}
+
+func getNewLineEditor(in io.Reader, out io.Writer, prompt string) *lineEditor {
+	return &lineEditor{
+		in:         bufio.NewReader(in),
+		out:        out,
+		prompt:     prompt,
+		maxHistory: 1000,
+	}
+}
+
+func (e *lineEditor) addHistory(line string) {
+	if line == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > e.maxHistory {
+		e.history = e.history[len(e.history)-e.maxHistory:]
+	}
+}
+
+// readLine returns the next line the user entered. It returns io.EOF on
+// Ctrl-D at an empty line and errInterrupted on Ctrl-C.
+func (e *lineEditor) readLine() (string, error) {
+	line := []rune{}
+	cursor := 0
+	historyPos := len(e.history)
+	draft := ""
+	e.redraw(line, cursor)
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case keyEnter, keyLineFeed:
+			e.write("\r\n")
+			return string(line), nil
+		case keyCtrlC:
+			e.write("^C\r\n")
+			return "", errInterrupted
+		case keyCtrlD:
+			if len(line) == 0 {
+				e.write("\r\n")
+				return "", io.EOF
+			}
+		case keyBackspace, keyCtrlH:
+			if cursor > 0 {
+				line = append(line[:cursor-1], line[cursor:]...)
+				cursor--
+			}
+		case keyCtrlA:
+			cursor = 0
+		case keyCtrlE:
+			cursor = len(line)
+		case keyCtrlU:
+			line = line[cursor:]
+			cursor = 0
+		case keyTab:
+			line, cursor = e.completeLine(line, cursor)
+		case keyEscape:
+			key, err := e.readEscape()
+			if err != nil {
+				return "", err
+			}
+			switch key {
+			case 'A':
+				if historyPos > 0 {
+					if historyPos == len(e.history) {
+						draft = string(line)
+					}
+					historyPos--
+					line = []rune(e.history[historyPos])
+					cursor = len(line)
+				}
+			case 'B':
+				if historyPos < len(e.history) {
+					historyPos++
+					if historyPos == len(e.history) {
+						line = []rune(draft)
+					} else {
+						line = []rune(e.history[historyPos])
+					}
+					cursor = len(line)
+				}
+			case 'C':
+				if cursor < len(line) {
+					cursor++
+				}
+			case 'D':
+				if cursor > 0 {
+					cursor--
+				}
+			case 'H':
+				cursor = 0
+			case 'F':
+				cursor = len(line)
+			}
+		default:
+			if r >= ' ' {
+				line = append(line[:cursor], append([]rune{r}, line[cursor:]...)...)
+				cursor++
+			}
+		}
+		e.redraw(line, cursor)
+	}
+}
+
+// readEscape consumes an ANSI escape sequence and returns its final byte.
+func (e *lineEditor) readEscape() (byte, error) {
+	next, err := e.in.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if next != '[' && next != 'O' {
+		return next, nil
+	}
+	for {
+		b, err := e.in.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b >= 0x40 && b <= 0x7e {
+			return b, nil
+		}
+	}
+}
+
+// completeLine completes the word under the cursor. A single candidate is
+// inserted in full, several candidates are extended to their common prefix
+// or listed when there is nothing left to extend.
+func (e *lineEditor) completeLine(line []rune, cursor int) ([]rune, int) {
+	if e.complete == nil {
+		return line, cursor
+	}
+	head := string(line[:cursor])
+	start := strings.LastIndex(head, " ") + 1
+	prefix := head[start:]
+	candidates := e.complete(head)
+	if len(candidates) == 0 {
+		return line, cursor
+	}
+	completion := candidates[0]
+	if len(candidates) == 1 {
+		completion += " "
+	} else {
+		for _, candidate := range candidates[1:] {
+			completion = commonPrefix(completion, candidate)
+		}
+		if completion == prefix {
+			e.write("\r\n" + strings.Join(candidates, "  ") + "\r\n")
+			return line, cursor
+		}
+	}
+	insert := []rune(completion[len(prefix):])
+	rest := append([]rune{}, line[cursor:]...)
+	line = append(append(line[:cursor], insert...), rest...)
+	return line, cursor + len(insert)
+}
+
+func (e *lineEditor) redraw(line []rune, cursor int) {
+	e.write("\r" + e.prompt + string(line) + "\x1b[K")
+	if back := len(line) - cursor; back > 0 {
+		e.write(fmt.Sprintf("\x1b[%dD", back))
+	}
+}
+
+func (e *lineEditor) write(s string) {
+	e.out.Write([]byte(s))
+}
+
+func commonPrefix(a, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return a[:i]
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLineEditor_readLine(t *testing.T) {
+	complete := func(line string) []string {
+		words := strings.Fields(line)
+		prefix := ""
+		if len(words) > 0 && !strings.HasSuffix(line, " ") {
+			prefix = words[len(words)-1]
+		}
+		return filterByPrefix([]string{"SEARCH", "SET", "hello", "help"}, prefix)
+	}
+	tests := []struct {
+		name    string
+		history []string
+		input   string
+		want    string
+		wantErr error
+	}{
+		{"plain line", nil, "ADD 1 hello\r", "ADD 1 hello", nil},
+		{"backspace", nil, "ADX\x7fD\r", "ADD", nil},
+		{"cursor movement", nil, "AD\x1b[D\x1b[DX\x1b[C\x1b[CD\r", "XADD", nil},
+		{"home and end", nil, "DD\x01A\x05!\r", "ADD!", nil},
+		{"kill to start", nil, "junk\x15ADD\r", "ADD", nil},
+		{"history up", []string{"first", "second"}, "\x1b[A\x1b[A\r", "first", nil},
+		{"history down back to draft", []string{"first"}, "dra\x1b[A\x1b[Bft\r", "draft", nil},
+		{"single completion", nil, "SEA\t1\r", "SEARCH 1", nil},
+		{"common prefix completion", nil, "SEARCH he\tlo\r", "SEARCH hello", nil},
+		{"ambiguous completion extends prefix", nil, "S\t\r", "SE", nil},
+		{"ambiguous completion lists candidates", nil, "SE\t\r", "SE", nil},
+		{"ctrl-c", nil, "ADD\x03", "", errInterrupted},
+		{"ctrl-d on empty line", nil, "\x04", "", io.EOF},
+		{"ctrl-d with text is ignored", nil, "A\x04\r", "A", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := getNewLineEditor(strings.NewReader(tt.input), io.Discard, "> ")
+			e.complete = complete
+			for _, line := range tt.history {
+				e.addHistory(line)
+			}
+			got, err := e.readLine()
+			if err != tt.wantErr {
+				t.Fatalf("readLine() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("readLine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_getReplCompletions(t *testing.T) {
+	store := getNewStore(10)
+	store.enableTrigrams()
+	if err := store.enableSegments(t.TempDir(), 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	store.upsertLog("1", "hello help world")
+	store.flush()
+	store.upsertLogs([]Log{{ID: "2", Data: "hi", Fields: map[string]string{"host": "web1"}}})
+	tests := []struct {
+		name string
+		line string
+		want []string
+	}{
+		{"empty line", "", replCommands},
+		{"command prefix", "SEA", []string{"SEARCH"}},
+		{"several commands", "SE", []string{"SEARCH", "SET"}},
+		{"lowercase command prefix", "sea", []string{"SEARCH"}},
+		{"rule subcommand", "RULE D", []string{"DEL"}},
+		{"indexed words", "SEARCH hel", []string{"hello", "help"}},
+		{"no matching words", "SEARCH zz", []string{}},
+		{"every word", "SEARCH ", []string{"hello", "help", "hi", "world"}},
+		{"fields", "SEARCH {ho", []string{"{host=web1}"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getReplCompletions(store, tt.line); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getReplCompletions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_processReplCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    string
+	}{
+		{"ids", "SEARCH foo 10", "2        2024-05-01T12:00:00Z  foo b\r\n1        2024-05-01T12:00:00Z  foo a\r\n"},
+		{"highlight", "SEARCH foo 10 HIGHLIGHT", "2        2024-05-01T12:00:00Z  [foo] b\r\n1        2024-05-01T12:00:00Z  [foo] a\r\n"},
+		{"page", "SEARCH foo 1 PAGE HIGHLIGHT", "2        2024-05-01T12:00:00Z  [foo] b\r\nCURSOR MTcxNDU2NDgwMDAwMDAwMDAwMDoyOjI\r\n"},
+		{"no results", "SEARCH bar 10", "NONE\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := getNewStore(10)
+			now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			store.now = func() time.Time { return now }
+			store.upsertLog("1", "foo a")
+			store.upsertLog("2", "foo b")
+			output := &bytes.Buffer{}
+			processReplCommand(getNewSession(store, output), tt.command)
+			if got := output.String(); got != tt.want {
+				t.Errorf("processReplCommand() output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockFile can't use flock here, so it only succeeds when it creates the
+// file. A process that didn't exit cleanly leaves the file behind, and it
+// has to be removed by hand.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("%s exists, remove it if no other process is running", path)
+	}
+	return f, err
+}
@@ -2,35 +2,100 @@ package main
 
 import (
 	"fmt"
+	"net/url"
+	"strings"
 	"time"
+	"unicode"
 )
 
-type LogID int
+// LogID is the opaque identifier clients use for a log, e.g. a number, a
+// UUID or a trace id.
+type LogID string
+
+// DocID is the dense internal id of a stored log. Posting lists hold DocIDs
+// so they stay compact whatever the client ids look like.
+type DocID uint32
+
+const maxLogIDLength = 256
 
 type Log struct {
-	ID                LogID
-	Data              string
-	CreatedAt         time.Time
+	ID        LogID
+	DocID     DocID
+	Data      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Version   int
+	// Seq orders logs created at the same time. The store numbers new logs
+	// in the order they arrive, and an update keeps the number.
+	Seq uint64
+	// Fields are named values indexed next to the words of Data, such as the
+	// host of a syslog message. Most logs have none.
+	Fields            map[string]string
 	MarkedForDeletion bool
 }
 
 func (l Log) copy() Log {
 	return Log{
 		ID:                l.ID,
+		DocID:             l.DocID,
 		Data:              l.Data,
 		CreatedAt:         l.CreatedAt,
+		UpdatedAt:         l.UpdatedAt,
+		Version:           l.Version,
+		Seq:               l.Seq,
+		Fields:            l.Fields,
 		MarkedForDeletion: l.MarkedForDeletion,
 	}
 }
 
 func (l Log) String() string {
-	return fmt.Sprintf("id: %d createdAt: %v data: %s", l.ID, l.CreatedAt, l.Data)
+	return fmt.Sprintf("id: %s createdAt: %v data: %s", l.ID, l.CreatedAt, l.Data)
 }
 
-func getNewLog(id LogID, data string) Log {
+func getNewLog(id LogID, data string, createdAt time.Time) Log {
 	return Log{
 		ID:        id,
 		Data:      data,
-		CreatedAt: time.Now(),
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+		Version:   1,
+	}
+}
+
+// encodeFields writes fields as a URL query, such as "app=sshd&host=web1",
+// which is how segments and snapshots store them.
+func encodeFields(fields map[string]string) string {
+	values := url.Values{}
+	for name, value := range fields {
+		values.Set(name, value)
+	}
+	return values.Encode()
+}
+
+func decodeFields(encoded string) (map[string]string, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	values, err := url.ParseQuery(encoded)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]string, len(values))
+	for name := range values {
+		fields[name] = values.Get(name)
+	}
+	return fields, nil
+}
+
+func parseLogID(s string) (LogID, error) {
+	if s == "" {
+		return "", fmt.Errorf("empty log id")
+	}
+	if len(s) > maxLogIDLength {
+		return "", fmt.Errorf("log id longer than %d bytes", maxLogIDLength)
+	}
+	if strings.IndexFunc(s, unicode.IsSpace) >= 0 {
+		return "", fmt.Errorf("log id contains whitespace")
 	}
+	return LogID(s), nil
 }
@@ -1,102 +1,490 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func main() {
-	dat, err := os.ReadFile("input_sample.txt")
-	check(err)
-	commands := strings.Split(string(dat), "\n")
-	filteredCommands := []string{}
-	for _, command := range commands {
-		if command != "" {
-			filteredCommands = append(filteredCommands, command)
+	os.Exit(runCLI(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+const maxLineLength = 1024 * 1024
+
+// inputStreamDriver processes commands one line at a time until END. Unless
+// the config sets a capacity, the first line of input is the storage limit.
+func inputStreamDriver(input io.Reader, output io.Writer, config storeConfig) error {
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	var session *Session
+	if config.capacity > 0 {
+		store, err := newConfiguredStore(config)
+		if err != nil {
+			return err
+		}
+		session = getNewSession(store, output)
+	}
+	for scanner.Scan() {
+		command := strings.TrimRight(scanner.Text(), "\r")
+		if command == "" {
+			continue
+		}
+		if session == nil {
+			storeLimit, err := strconv.Atoi(command)
+			if err != nil {
+				return fmt.Errorf("invalid storage limit passed")
+			}
+			config.capacity = storeLimit
+			store, err := newConfiguredStore(config)
+			if err != nil {
+				return err
+			}
+			session = getNewSession(store, output)
+			continue
 		}
+		session.execute(command)
+		if command == "END" {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if session == nil {
+		return fmt.Errorf("the input should have at least 2 commands")
 	}
+	return fmt.Errorf("no end received, the last command has to be END")
+}
 
-	inputFileDriver(filteredCommands)
+// processCommand runs a single protocol line in text mode. A malformed line
+// gets an "ERR <code> <message>" response instead of stopping the process.
+func processCommand(store *Storage, command string, output io.Writer) {
+	getNewSession(store, output).execute(command)
 }
 
-func check(e error) {
-	if e != nil {
-		panic(e)
+func runCommandLine(session *Session, command string) (response Response, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = newCommandError(ErrInternal, "%v", r)
+		}
+	}()
+
+	store := session.store
+	arguments := strings.Fields(command)
+	if len(arguments) == 0 {
+		return response, newCommandError(ErrUnknownCommand, "empty command")
+	}
+	switch arguments[0] {
+	case "END":
+		return processEnd()
+	case "PROTO":
+		return session.processProto(arguments)
+	case "ADD", "ADDAT":
+		if session.batch != nil {
+			return session.queueAdd(command)
+		}
+		return processAdd(store, command)
+	case "APPEND":
+		if session.batch != nil {
+			return session.queueAppend(command)
+		}
+		return processAppend(store, command)
+	case "BEGIN":
+		return session.processBegin()
+	case "COMMIT":
+		return session.processCommit()
+	case "ABORT":
+		return session.processAbort()
+	case "SEARCH":
+		return processSearch(session, command)
+	case "SET":
+		return session.processSet(arguments)
+	case "HISTORY":
+		return processHistory(store, command)
+	case "RULE":
+		return processRule(store, command)
+	case "FLUSH":
+		return processFlush(store)
+	case "COMPACT":
+		return processCompact(store, arguments)
+	case "PATTERNS":
+		return processPatterns(store, arguments)
+	case "STATS":
+		return processStats(store, arguments)
+	case "INFO":
+		return processInfo(store, arguments)
+	case "TERM":
+		return processTerm(store, arguments)
+	case "CHECK":
+		return processCheck(store, arguments)
+	case "EXPORT":
+		return session.processExport(arguments)
+	case "IMPORT":
+		return session.processImport(arguments)
+	case importEnd:
+		return session.processImportEnd()
 	}
+	return response, newCommandError(ErrUnknownCommand, "unknown command %q", arguments[0])
 }
 
-func inputFileDriver(commands []string) {
-	if len(commands) < 2 {
-		panic("the input should have at least 2 commands")
-	}
-	storeLimit, err := strconv.Atoi(commands[0])
+func processEnd() (Response, error) {
+	return Response{Status: StatusEnd, text: []string{"END"}}, nil
+}
+
+func processAdd(store *Storage, command string) (Response, error) {
+	log, err := parseAdd(command)
 	if err != nil {
-		panic("invalid storage limit passed")
-	}
-	endCommand := commands[len(commands)-1]
-	if endCommand != "END" {
-		panic("no end received, the last command has to be END")
-	}
-	store := getNewStore(storeLimit)
-	commands = commands[1:]
-	for _, command := range commands {
-		processCommand(store, command, os.Stdout)
+		return Response{}, err
 	}
+	store.upsertLogs([]Log{log})
+	return Response{Status: StatusOK, Results: []Result{{ID: log.ID}}}, nil
 }
 
-func processCommand(store *Storage, command string, output io.Writer) {
-	if command == "END" {
-		processEnd(output)
-		return
+func processAppend(store *Storage, command string) (Response, error) {
+	data, err := parseAppend(command)
+	if err != nil {
+		return Response{}, err
 	}
+	id := store.appendLog(data)
+	return Response{Status: StatusOK, Results: []Result{{ID: id}}, text: []string{string(id)}}, nil
+}
 
-	if len(command) >= 3 && command[:3] == "ADD" {
-		processAdd(store, command)
-		return
+func parseAppend(command string) (string, error) {
+	arguments := strings.SplitN(command, " ", 2)
+	if len(arguments) < 2 || arguments[1] == "" {
+		return "", newCommandError(ErrBadArgument, "usage: APPEND [text]")
 	}
+	return arguments[1], nil
+}
 
-	if len(command) >= 6 && command[:6] == "SEARCH" {
-		processSearch(store, command, output)
-		return
-	}
+const (
+	addUsage   = "usage: ADD [key] [text]"
+	addAtUsage = "usage: ADDAT [key] [time] [text]"
+)
 
-	panic("invalid command")
+// parseAdd reads "ADD [key] [text]" or "ADDAT [key] [time] [text]", which
+// stores the log with an RFC 3339 time instead of the current one.
+func parseAdd(command string) (Log, error) {
+	usage, n := addUsage, 3
+	if strings.HasPrefix(command, "ADDAT") {
+		usage, n = addAtUsage, 4
+	}
+	arguments := strings.SplitN(command, " ", n)
+	if len(arguments) < n || arguments[n-1] == "" {
+		return Log{}, newCommandError(ErrBadArgument, usage)
+	}
+	logId, err := parseLogID(arguments[1])
+	if err != nil {
+		return Log{}, newCommandError(ErrBadArgument, "invalid key id %q: %v", arguments[1], err)
+	}
+	log := Log{ID: logId, Data: arguments[n-1]}
+	if n == 4 {
+		if log.CreatedAt, err = time.Parse(time.RFC3339, arguments[2]); err != nil {
+			return Log{}, newCommandError(ErrBadArgument, "invalid time %q", arguments[2])
+		}
+	}
+	return log, nil
+}
 
+type searchRequest struct {
+	query     string
+	substring string
+	pattern   *regexp.Regexp
+	limit     int
+	opts      SearchOptions
+	page      bool
+	highlight bool
+	snippet   int
 }
 
-func processEnd(output io.Writer) {
-	output.Write([]byte("END\r\n"))
+const searchUsage = "usage: SEARCH [word | {name=value} | *substring* | /regexp/] [limit] [HISTORY] [PAGE | AFTER cursor] [HIGHLIGHT] [SNIPPET width]"
+
+func parseSearch(command string) (searchRequest, error) {
+	request := searchRequest{}
+	rest := strings.TrimPrefix(strings.TrimSpace(command), "SEARCH")
+	rest = strings.TrimLeft(rest, " ")
+	var arguments []string
+	if strings.HasPrefix(rest, "/") {
+		end := findRegexpEnd(rest)
+		if end < 0 {
+			return searchRequest{}, newCommandError(ErrBadArgument, searchUsage)
+		}
+		pattern, err := regexp.Compile(rest[1:end])
+		if err != nil {
+			return searchRequest{}, newCommandError(ErrBadArgument, "invalid regexp: %v", err)
+		}
+		request.query = rest[:end+1]
+		request.pattern = pattern
+		arguments = strings.Fields(rest[end+1:])
+	} else {
+		arguments = strings.Fields(rest)
+		if len(arguments) > 0 {
+			request.query = arguments[0]
+			arguments = arguments[1:]
+		}
+		if len(request.query) > 2 && strings.HasPrefix(request.query, "*") && strings.HasSuffix(request.query, "*") {
+			request.substring = request.query[1 : len(request.query)-1]
+			request.pattern = substringPattern(request.substring)
+		}
+	}
+	if request.query == "" || len(arguments) < 1 {
+		return searchRequest{}, newCommandError(ErrBadArgument, searchUsage)
+	}
+	limit, err := strconv.Atoi(arguments[0])
+	if err != nil || limit < 0 {
+		return searchRequest{}, newCommandError(ErrBadArgument, "invalid limit %q", arguments[0])
+	}
+	request.limit = limit
+	options := arguments[1:]
+	for len(options) > 0 {
+		option := options[0]
+		options = options[1:]
+		switch strings.ToUpper(option) {
+		case "HISTORY":
+			request.opts.IncludeHistory = true
+		case "PAGE":
+			request.page = true
+		case "HIGHLIGHT":
+			request.highlight = true
+		case "SNIPPET":
+			if len(options) == 0 {
+				return searchRequest{}, newCommandError(ErrBadArgument, searchUsage)
+			}
+			width, err := strconv.Atoi(options[0])
+			if err != nil || width <= 0 {
+				return searchRequest{}, newCommandError(ErrBadArgument, "invalid snippet width %q", options[0])
+			}
+			options = options[1:]
+			request.snippet = width
+			request.highlight = true
+		case "AFTER":
+			if len(options) == 0 {
+				return searchRequest{}, newCommandError(ErrBadArgument, searchUsage)
+			}
+			position, err := decodeCursor(options[0])
+			if err != nil {
+				return searchRequest{}, newCommandError(ErrBadArgument, "%v %q", err, options[0])
+			}
+			options = options[1:]
+			request.opts.After = &position
+			request.page = true
+		default:
+			return searchRequest{}, newCommandError(ErrBadArgument, "unknown search option %q", option)
+		}
+	}
+	return request, nil
 }
 
-func processAdd(store *Storage, command string) {
-	arguments := strings.Split(command, " ")
-	idLen := len(arguments[1])
-	logId, err := strconv.Atoi(arguments[1])
-	if err != nil {
-		fmt.Print("invalid key id\r\n")
+// findRegexpEnd returns the index of the slash closing a /regexp/ query,
+// the last one that is followed by a space or ends the line.
+func findRegexpEnd(rest string) int {
+	for end := len(rest) - 1; end > 0; end-- {
+		if rest[end] == '/' && (end == len(rest)-1 || rest[end+1] == ' ') {
+			return end
+		}
 	}
-	data := command[5+idLen:]
-	store.upsertLog(LogID(logId), data)
+	return -1
 }
 
-func processSearch(store *Storage, command string, output io.Writer) {
-	arguments := strings.Split(command, " ")
-	query := arguments[1]
-	limit, err := strconv.Atoi(arguments[2])
+func processSearch(session *Session, command string) (Response, error) {
+	store := session.store
+	request, err := parseSearch(command)
 	if err != nil {
-		fmt.Print("invalid limit")
+		return Response{}, err
 	}
-	logs := store.getLogsByWord(query, limit)
+	var logs []Log
+	kind := searchWord
+	start := time.Now()
+	if request.substring != "" {
+		kind = searchSubstring
+		logs = store.searchSubstring(request.substring, request.limit, request.opts)
+	} else if request.pattern != nil {
+		kind = searchRegexp
+		logs = store.searchRegexp(request.pattern, request.limit, request.opts)
+	} else {
+		logs = store.searchLogs(request.query, request.limit, request.opts)
+	}
+	store.metrics.searches[kind].observe(time.Since(start).Seconds())
+	response := Response{Status: StatusOK, Results: []Result{}}
 	if logs == nil || len(logs) == 0 {
-		output.Write([]byte("NONE\r\n"))
-		return
+		response.text = []string{"NONE"}
+		return response, nil
 	}
 	logIds := []string{}
 	for _, log := range logs {
-		logIds = append(logIds, strconv.Itoa(int(log.ID)))
+		logIds = append(logIds, string(log.ID))
+		var matches []Token
+		if request.pattern != nil {
+			matches = regexpMatches(request.pattern, log.Data)
+		} else {
+			matches = matchedTokens(store.analyzer, log.Data, request.query)
+		}
+		result := getResult(log, float64(len(matches)))
+		if request.highlight {
+			result.Highlight = session.highlighter.snippet(log.Data, matches, request.snippet)
+			response.text = append(response.text, string(log.ID)+" "+result.Highlight)
+		}
+		response.Results = append(response.Results, result)
+	}
+	if !request.highlight {
+		response.text = []string{strings.Join(logIds, " ")}
+	}
+	cursor := encodeCursor(getSearchPosition(logs[len(logs)-1]))
+	response.Data = map[string]string{"cursor": cursor}
+	if request.page {
+		response.text = append(response.text, "CURSOR "+cursor)
+	}
+	return response, nil
+}
+
+func processHistory(store *Storage, command string) (Response, error) {
+	arguments := strings.Fields(command)
+	if len(arguments) != 2 {
+		return Response{}, newCommandError(ErrBadArgument, "usage: HISTORY [id]")
+	}
+	versions, err := store.getHistory(LogID(arguments[1]))
+	if err != nil {
+		return Response{}, newCommandError(ErrNotFound, "log %q not found", arguments[1])
+	}
+	response := Response{Status: StatusOK}
+	for _, version := range versions {
+		response.Results = append(response.Results, getResult(version, 0))
+		line := fmt.Sprintf("%d %s %s", version.Version, version.UpdatedAt.Format(time.RFC3339Nano), version.Data)
+		response.text = append(response.text, line)
+	}
+	return response, nil
+}
+
+func processFlush(store *Storage) (Response, error) {
+	if store.segments == nil {
+		return Response{}, newCommandError(ErrBadState, "segments are disabled")
+	}
+	count, err := store.flush()
+	if err != nil {
+		return Response{}, err
+	}
+	return countResponse(count), nil
+}
+
+// processCompact merges segments as the merge policy asks, or all of them
+// with ALL, and replies with the number of merges.
+func processCompact(store *Storage, arguments []string) (Response, error) {
+	if store.segments == nil {
+		return Response{}, newCommandError(ErrBadState, "segments are disabled")
+	}
+	all := len(arguments) == 2 && strings.ToUpper(arguments[1]) == "ALL"
+	if len(arguments) > 2 || (len(arguments) == 2 && !all) {
+		return Response{}, newCommandError(ErrBadArgument, "usage: COMPACT [ALL]")
+	}
+	merges, err := store.compact(all)
+	if err != nil {
+		return Response{}, err
+	}
+	return Response{
+		Status: StatusOK,
+		Data:   store.segments.stats,
+		text:   []string{fmt.Sprintf("OK %d", merges)},
+	}, nil
+}
+
+const defaultPatternCount = 10
+
+// processPatterns lists the most common templates as
+// "[count] [example ids] [template]" lines. A single numeric argument is the
+// count, anything else is the query.
+func processPatterns(store *Storage, arguments []string) (Response, error) {
+	if len(arguments) > 3 {
+		return Response{}, newCommandError(ErrBadArgument, "usage: PATTERNS [query] [n]")
+	}
+	query, n := "", defaultPatternCount
+	arguments = arguments[1:]
+	if len(arguments) > 0 {
+		last := arguments[len(arguments)-1]
+		if count, err := strconv.Atoi(last); err == nil {
+			if count <= 0 {
+				return Response{}, newCommandError(ErrBadArgument, "invalid count %q", last)
+			}
+			n = count
+			arguments = arguments[:len(arguments)-1]
+		} else if len(arguments) == 2 {
+			return Response{}, newCommandError(ErrBadArgument, "invalid count %q", last)
+		}
+	}
+	if len(arguments) > 0 {
+		query = arguments[0]
+	}
+	patterns := store.getPatterns(query, n)
+	lines := []string{}
+	for _, pattern := range patterns {
+		examples := []string{}
+		for _, id := range pattern.Examples {
+			examples = append(examples, string(id))
+		}
+		lines = append(lines, fmt.Sprintf("%d %s %s", pattern.Count, strings.Join(examples, ","), pattern.Template))
+	}
+	if len(lines) == 0 {
+		lines = []string{"NONE"}
+	}
+	return Response{Status: StatusOK, Data: patterns, text: lines}, nil
+}
+
+type ruleStatus struct {
+	AlertRule
+	State AlertState `json:"state"`
+}
+
+func processRule(store *Storage, command string) (Response, error) {
+	arguments := strings.Fields(command)
+	if len(arguments) < 2 {
+		return Response{}, newCommandError(ErrBadArgument, "usage: RULE ADD|DEL|LIST")
+	}
+	switch arguments[1] {
+	case "ADD":
+		if len(arguments) != 6 {
+			return Response{}, newCommandError(ErrBadArgument, "usage: RULE ADD [name] [query] [window] [threshold]")
+		}
+		window, err := time.ParseDuration(arguments[4])
+		if err != nil {
+			return Response{}, newCommandError(ErrBadArgument, "invalid window %q", arguments[4])
+		}
+		threshold, err := strconv.Atoi(arguments[5])
+		if err != nil {
+			return Response{}, newCommandError(ErrBadArgument, "invalid threshold %q", arguments[5])
+		}
+		rule := AlertRule{Name: arguments[2], Query: arguments[3], Window: window, Threshold: threshold}
+		if err := store.alerts.addRule(rule); err != nil {
+			return Response{}, newCommandError(ErrBadArgument, "%v", err)
+		}
+	case "DEL":
+		if len(arguments) != 3 {
+			return Response{}, newCommandError(ErrBadArgument, "usage: RULE DEL [name]")
+		}
+		if err := store.alerts.deleteRule(arguments[2]); err != nil {
+			return Response{}, newCommandError(ErrNotFound, "%v", err)
+		}
+	case "LIST":
+		rules := []ruleStatus{}
+		lines := []string{}
+		for _, rule := range store.alerts.listRules() {
+			state := AlertResolved
+			if store.alerts.isFiring(rule.Name) {
+				state = AlertFiring
+			}
+			rules = append(rules, ruleStatus{AlertRule: rule, State: state})
+			lines = append(lines, fmt.Sprintf("%s %s %v %d %s", rule.Name, rule.Query, rule.Window, rule.Threshold, state))
+		}
+		if len(lines) == 0 {
+			lines = []string{"NONE"}
+		}
+		return Response{Status: StatusOK, Data: rules, text: lines}, nil
+	default:
+		return Response{}, newCommandError(ErrBadArgument, "unknown rule command %q", arguments[1])
 	}
-	output.Write([]byte(strings.Join(logIds, " ") + "\r\n"))
+	return Response{Status: StatusOK}, nil
 }
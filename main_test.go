@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_processCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		want     string
+	}{
+		{
+			"sample session",
+			[]string{"ADD 25 the first", "SEARCH the 1", "ADD 56 the second log", "SEARCH the 2", "END"},
+			"25\r\n56 25\r\nEND\r\n",
+		},
+		{
+			"no results",
+			[]string{"ADD 25 the first", "SEARCH nothing 1"},
+			"NONE\r\n",
+		},
+		{
+			"unknown command",
+			[]string{"DROP 25"},
+			"ERR UNKNOWN_COMMAND unknown command \"DROP\"\r\n",
+		},
+		{
+			"command prefix is not a command",
+			[]string{"ADDED 25 the first"},
+			"ERR UNKNOWN_COMMAND unknown command \"ADDED\"\r\n",
+		},
+		{
+			"empty command",
+			[]string{"   "},
+			"ERR UNKNOWN_COMMAND empty command\r\n",
+		},
+		{
+			"add without text",
+			[]string{"ADD 25"},
+			"ERR BAD_ARGUMENT usage: ADD [key] [text]\r\n",
+		},
+		{
+			"add with empty id",
+			[]string{"ADD  the first", "SEARCH the 1"},
+			"ERR BAD_ARGUMENT invalid key id \"\": empty log id\r\nNONE\r\n",
+		},
+		{
+			"add with string ids",
+			[]string{
+				"ADD 9f1c2a4e-7b1d-4c3e-9a55-0d2f7c9b1e11 the first",
+				"ADD 18446744073709551615 the second",
+				"ADD trace:4bf92f3577b34da6 the third",
+				"SEARCH the 3",
+			},
+			"trace:4bf92f3577b34da6 18446744073709551615 9f1c2a4e-7b1d-4c3e-9a55-0d2f7c9b1e11\r\n",
+		},
+		{
+			"search without limit",
+			[]string{"SEARCH the"},
+			"ERR BAD_ARGUMENT usage: SEARCH [word | {name=value} | *substring* | /regexp/] [limit] [HISTORY] [PAGE | AFTER cursor] [HIGHLIGHT] [SNIPPET width]\r\n",
+		},
+		{
+			"search with invalid limit",
+			[]string{"SEARCH the many"},
+			"ERR BAD_ARGUMENT invalid limit \"many\"\r\n",
+		},
+		{
+			"search with negative limit",
+			[]string{"SEARCH the -1"},
+			"ERR BAD_ARGUMENT invalid limit \"-1\"\r\n",
+		},
+		{
+			"keeps serving after an error",
+			[]string{"BOGUS", "ADD 1 hello", "SEARCH hello 1"},
+			"ERR UNKNOWN_COMMAND unknown command \"BOGUS\"\r\n1\r\n",
+		},
+		{
+			"append assigns increasing ids",
+			[]string{"APPEND hello world", "APPEND hello again", "SEARCH hello 5"},
+			"1\r\n2\r\n2 1\r\n",
+		},
+		{
+			"append never reuses client ids",
+			[]string{"ADD 7 hello", "APPEND hello again", "ADD 3 hello", "APPEND hello", "SEARCH hello 5"},
+			"8\r\n9\r\n9 3 8\r\n",
+		},
+		{
+			"append without text",
+			[]string{"APPEND"},
+			"ERR BAD_ARGUMENT usage: APPEND [text]\r\n",
+		},
+		{
+			"addat back-fills",
+			[]string{"ADDAT 1 2024-05-01T12:00:00Z event a", "ADD 2 event b", "ADDAT 3 2020-01-01T00:00:00+02:00 event c", "SEARCH event 3"},
+			"2 1 3\r\n",
+		},
+		{
+			"addat with an invalid time",
+			[]string{"ADDAT 1 2024-13-01T00:00:00Z event", "ADDAT 1 2024-05-01T12:00:00Z", "ADDAT 1 @2pm meeting"},
+			"ERR BAD_ARGUMENT invalid time \"2024-13-01T00:00:00Z\"\r\nERR BAD_ARGUMENT usage: ADDAT [key] [time] [text]\r\n" +
+				"ERR BAD_ARGUMENT invalid time \"@2pm\"\r\n",
+		},
+		{
+			"add text starting with @",
+			[]string{"ADD 1 @alice logged in", "ADD 2 @2pm meeting", "ADD 3 @1 replied", "SEARCH @alice 1", "SEARCH @2pm 1", "SEARCH @1 1"},
+			"1\r\n2\r\n3\r\n",
+		},
+		{
+			"rule lifecycle",
+			[]string{"RULE ADD panics panic 5m 50", "RULE LIST", "RULE DEL panics", "RULE LIST"},
+			"panics panic 5m0s 50 resolved\r\nNONE\r\n",
+		},
+		{
+			"rule with invalid window",
+			[]string{"RULE ADD panics panic soon 50"},
+			"ERR BAD_ARGUMENT invalid window \"soon\"\r\n",
+		},
+		{
+			"delete unknown rule",
+			[]string{"RULE DEL panics"},
+			"ERR NOT_FOUND rule not found\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := getNewStore(3)
+			output := &bytes.Buffer{}
+			for _, command := range tt.commands {
+				processCommand(store, command, output)
+			}
+			if got := output.String(); got != tt.want {
+				t.Errorf("processCommand() output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_inputStreamDriver(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr string
+	}{
+		{"valid input", "3\nADD 1 hello\nSEARCH hello 1\nEND\n", "1\r\nEND\r\n", ""},
+		{"invalid header", "many\nEND\n", "", "invalid storage limit passed"},
+		{"empty input", "", "", "the input should have at least 2 commands"},
+		{"missing end", "3\nADD 1 hello\n", "", "no end received, the last command has to be END"},
+		{"bad line keeps going", "3\nOOPS\nEND\n", "ERR UNKNOWN_COMMAND unknown command \"OOPS\"\r\nEND\r\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := &bytes.Buffer{}
+			err := inputStreamDriver(strings.NewReader(tt.input), output, storeConfig{tokenizer: "whitespace"})
+			if (err == nil) != (tt.wantErr == "") || (err != nil && err.Error() != tt.wantErr) {
+				t.Fatalf("inputStreamDriver() error = %v, want %q", err, tt.wantErr)
+			}
+			if got := output.String(); got != tt.want {
+				t.Errorf("inputStreamDriver() output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzParseAdd(f *testing.F) {
+	for _, seed := range []string{"ADD 1 hello", "ADD  x", "ADD 1", "ADD trace:4bf9 GET /a b", "ADD 1 \t", "ADD é ü", "ADDAT 1 2024-05-01T12:00:00.5+02:00 hi", "ADD 1 @9", "ADDAT 1 9 x"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, command string) {
+		log, err := parseAdd(command)
+		if err != nil {
+			return
+		}
+		if _, idErr := parseLogID(string(log.ID)); idErr != nil || log.Data == "" {
+			t.Fatalf("parseAdd(%q) = %+v, an invalid log", command, log)
+		}
+		reassembled := "ADD " + string(log.ID) + " " + log.Data
+		if !log.CreatedAt.IsZero() {
+			reassembled = "ADDAT " + string(log.ID) + " " + log.CreatedAt.Format(time.RFC3339Nano) + " " + log.Data
+		}
+		again, err := parseAdd(reassembled)
+		if err != nil || again.ID != log.ID || again.Data != log.Data || !again.CreatedAt.Equal(log.CreatedAt) {
+			t.Errorf("parseAdd() of the reassembled command = %+v, %v, want %+v", again, err, log)
+		}
+	})
+}
+
+func FuzzParseSearch(f *testing.F) {
+	for _, seed := range []string{
+		"SEARCH disk 5",
+		"SEARCH *sk f* 1 HISTORY",
+		"SEARCH /disk (full|ok)/ 2 HIGHLIGHT SNIPPET 10",
+		"SEARCH / a/ 3",
+		"SEARCH ** 1 AFTER MTIzOng",
+		"SEARCH disk 1 PAGE AFTER",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, command string) {
+		request, err := parseSearch(command)
+		if err != nil {
+			return
+		}
+		if request.query == "" || request.limit < 0 || request.snippet < 0 {
+			t.Fatalf("parseSearch(%q) = %+v", command, request)
+		}
+		if request.substring != "" && request.pattern == nil {
+			t.Errorf("parseSearch(%q) has a substring without its pattern", command)
+		}
+		if request.opts.After != nil && !request.page {
+			t.Errorf("parseSearch(%q) has a cursor but isn't paged", command)
+		}
+	})
+}
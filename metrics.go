@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+const metricsPrefix = "log_search_"
+
+// searchBuckets are the upper bounds, in seconds, of the SEARCH latency
+// histograms.
+var searchBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// Search kinds label the SEARCH latency histograms.
+const (
+	searchWord      = "word"
+	searchSubstring = "substring"
+	searchRegexp    = "regexp"
+)
+
+type histogram struct {
+	bounds []float64
+	// counts holds the observations of each bucket, not cumulated, with the
+	// last one for values above every bound.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func getNewHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(value float64) {
+	bucket := sort.SearchFloat64s(h.bounds, value)
+	h.counts[bucket]++
+	h.sum += value
+	h.count++
+}
+
+// storeMetrics counts what a store has done since it started. Like the rest
+// of the store it is guarded by Storage.mu.
+type storeMetrics struct {
+	ingested    uint64
+	inserts     uint64
+	updates     uint64
+	evictions   uint64
+	expirations uint64
+	// storedBytes is the size of the log data held in memory.
+	storedBytes int64
+	searches    map[string]*histogram
+}
+
+func getNewStoreMetrics() storeMetrics {
+	return storeMetrics{
+		searches: map[string]*histogram{
+			searchWord:      getNewHistogram(searchBuckets),
+			searchSubstring: getNewHistogram(searchBuckets),
+			searchRegexp:    getNewHistogram(searchBuckets),
+		},
+	}
+}
+
+// stats returns the number of keys that still have entries and the total
+// number of entries over all keys.
+func (i *InvertedIndex) stats() (keys int, entries int) {
+	for _, docs := range i.keyToEntries {
+		if len(docs) > 0 {
+			keys++
+			entries += len(docs)
+		}
+	}
+	return keys, entries
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func writeMetricHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, kind)
+}
+
+func writeMetric(w io.Writer, name, kind, help string, value float64) {
+	writeMetricHeader(w, name, kind, help)
+	fmt.Fprintf(w, "%s%s %s\n", metricsPrefix, name, formatMetricValue(value))
+}
+
+// writeHistograms writes one histogram per label value of label.
+func writeHistograms(w io.Writer, name, help, label string, histograms map[string]*histogram) {
+	writeMetricHeader(w, name, "histogram", help)
+	values := []string{}
+	for value := range histograms {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	for _, value := range values {
+		h := histograms[value]
+		labels := fmt.Sprintf("%s=%q", label, value)
+		cumulative := uint64(0)
+		for i, bound := range h.bounds {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "%s%s_bucket{%s,le=\"%s\"} %d\n", metricsPrefix, name, labels, formatMetricValue(bound), cumulative)
+		}
+		fmt.Fprintf(w, "%s%s_bucket{%s,le=\"+Inf\"} %d\n", metricsPrefix, name, labels, h.count)
+		fmt.Fprintf(w, "%s%s_sum{%s} %s\n", metricsPrefix, name, labels, formatMetricValue(h.sum))
+		fmt.Fprintf(w, "%s%s_count{%s} %d\n", metricsPrefix, name, labels, h.count)
+	}
+}
+
+// writeMetrics writes the store metrics in the Prometheus text exposition
+// format. The store must be locked.
+func (s *Storage) writeMetrics(w io.Writer) {
+	m := &s.metrics
+	writeMetric(w, "logs_ingested_total", "counter", "Logs written by ADD, APPEND, IMPORT and ingest.", float64(m.ingested))
+	writeMetric(w, "log_inserts_total", "counter", "Ingested logs that were new.", float64(m.inserts))
+	writeMetric(w, "log_updates_total", "counter", "Ingested logs that replaced an existing log with the same id.", float64(m.updates))
+	writeMetric(w, "evictions_total", "counter", "Logs evicted because the store was over capacity.", float64(m.evictions))
+	writeMetric(w, "expirations_total", "counter", "Logs dropped because they outlived the ttl.", float64(m.expirations))
+	writeHistograms(w, "search_duration_seconds", "SEARCH latency by kind of query.", "kind", m.searches)
+
+	keys, entries := s.index.stats()
+	writeMetric(w, "logs", "gauge", "Logs currently stored.", float64(s.buffer.Len()))
+	writeMetric(w, "capacity", "gauge", "Logs kept before the oldest are evicted.", float64(s.capacity))
+	writeMetric(w, "index_terms", "gauge", "Distinct words in the in-memory term dictionary.", float64(keys))
+	writeMetric(w, "index_postings", "gauge", "Entries over all in-memory posting lists.", float64(entries))
+	writeMetric(w, "stored_bytes", "gauge", "Bytes of log data held in memory.", float64(m.storedBytes))
+
+	if s.segments == nil {
+		return
+	}
+	segmentBytes := 0
+	for _, seg := range s.segments.list {
+		segmentBytes += len(seg.data)
+	}
+	stats := s.segments.stats
+	writeMetric(w, "segments", "gauge", "On-disk segments.", float64(len(s.segments.list)))
+	writeMetric(w, "segment_bytes", "gauge", "Size of the on-disk segments.", float64(segmentBytes))
+	writeMetric(w, "compaction_merges_total", "counter", "Segment merges run.", float64(stats.Merges))
+	writeMetric(w, "compaction_segments_merged_total", "counter", "Segments replaced by merges.", float64(stats.SegmentsMerged))
+	writeMetric(w, "compaction_logs_dropped_total", "counter", "Deleted logs left out by merges.", float64(stats.LogsDropped))
+	writeMetric(w, "compaction_bytes_reclaimed_total", "counter", "Segment bytes freed by merges.", float64(stats.BytesReclaimed))
+}
+
+// metricsHandler serves the store metrics for Prometheus to scrape.
+func metricsHandler(store *Storage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := &bytes.Buffer{}
+		store.mu.Lock()
+		store.writeMetrics(body)
+		store.mu.Unlock()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(body.Bytes())
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_histogram_observe(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   []uint64
+	}{
+		{"empty", nil, []uint64{0, 0, 0}},
+		{"on a bound", []float64{1, 2}, []uint64{1, 1, 0}},
+		{"between bounds", []float64{0.5, 1.5, 1.7}, []uint64{1, 2, 0}},
+		{"above every bound", []float64{3, 10}, []uint64{0, 0, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := getNewHistogram([]float64{1, 2})
+			sum := 0.0
+			for _, value := range tt.values {
+				h.observe(value)
+				sum += value
+			}
+			if !reflect.DeepEqual(h.counts, tt.want) {
+				t.Errorf("counts = %v, want %v", h.counts, tt.want)
+			}
+			if h.count != uint64(len(tt.values)) || h.sum != sum {
+				t.Errorf("count, sum = %d, %v, want %d, %v", h.count, h.sum, len(tt.values), sum)
+			}
+		})
+	}
+}
+
+func TestStorage_writeMetrics(t *testing.T) {
+	store := getNewStore(2)
+	session := getNewSession(store, &bytes.Buffer{})
+	for _, command := range []string{
+		"ADD 1 disk full",
+		"ADD 1 disk almost full",
+		"ADD 2 cpu hot",
+		"ADD 3 cpu cold",
+		"SEARCH cpu 5",
+		"SEARCH *ol* 5",
+	} {
+		session.execute(command)
+	}
+	output := &bytes.Buffer{}
+	store.writeMetrics(output)
+	got := output.String()
+
+	for _, want := range []string{
+		"# TYPE log_search_logs_ingested_total counter\nlog_search_logs_ingested_total 4\n",
+		"log_search_log_inserts_total 3\n",
+		"log_search_log_updates_total 1\n",
+		"log_search_evictions_total 1\n",
+		"log_search_logs 2\n",
+		"log_search_capacity 2\n",
+		"log_search_index_terms 3\n",
+		"log_search_index_postings 4\n",
+		"log_search_stored_bytes 15\n",
+		"# TYPE log_search_search_duration_seconds histogram\n",
+		`log_search_search_duration_seconds_bucket{kind="word",le="+Inf"} 1` + "\n",
+		`log_search_search_duration_seconds_count{kind="substring"} 1` + "\n",
+		`log_search_search_duration_seconds_count{kind="regexp"} 0` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("writeMetrics() is missing %q in\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "log_search_segments") {
+		t.Errorf("writeMetrics() reports segments while they are disabled")
+	}
+}
+
+func TestStorage_writeMetricsSegments(t *testing.T) {
+	s := segmentedStore(t, 10, []string{"1", "2"}, []string{"3"})
+	s.deleteLogById("1")
+	if _, err := s.compact(true); err != nil {
+		t.Fatal(err)
+	}
+	output := &bytes.Buffer{}
+	s.writeMetrics(output)
+	got := output.String()
+	for _, want := range []string{
+		"log_search_stored_bytes 0\n",
+		"log_search_segments 1\n",
+		"log_search_compaction_merges_total 1\n",
+		"log_search_compaction_segments_merged_total 2\n",
+		"log_search_compaction_logs_dropped_total 1\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("writeMetrics() is missing %q in\n%s", want, got)
+		}
+	}
+}
+
+func Test_metricsHandler(t *testing.T) {
+	store := getNewStore(10)
+	store.upsertLog("1", "hello")
+	recorder := httptest.NewRecorder()
+	metricsHandler(store).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if got := recorder.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := recorder.Body.String(); !strings.Contains(got, "log_search_logs_ingested_total 1\n") {
+		t.Errorf("body = %q", got)
+	}
+}
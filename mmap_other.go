@@ -0,0 +1,20 @@
+//go:build !linux
+
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile is only implemented with mmap on linux, elsewhere segments are
+// read into memory.
+func mapFile(f *os.File, size int) ([]byte, error) {
+	data := make([]byte, size)
+	_, err := io.ReadFull(f, data)
+	return data, err
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
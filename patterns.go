@@ -0,0 +1,241 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// wildcard stands for the variable parts of a template.
+const wildcard = "<*>"
+
+const (
+	defaultPatternDepth       = 1
+	defaultPatternSimilarity  = 0.5
+	defaultPatternMaxChildren = 100
+	patternExamples           = 3
+)
+
+// patternCluster is a group of logs sharing a template, together with the
+// logs currently in it.
+type patternCluster struct {
+	template []string
+	members  map[LogID]searchPosition
+	path     []string
+}
+
+// patternNode is a node of the Drain parse tree. The first level is keyed by
+// the token count, the next depth levels by the leading tokens, and the
+// leaves hold the clusters.
+type patternNode struct {
+	children map[string]*patternNode
+	clusters []*patternCluster
+}
+
+func getNewPatternNode() *patternNode {
+	return &patternNode{children: map[string]*patternNode{}}
+}
+
+// patternMiner groups log lines into templates with the Drain algorithm.
+// Tokens holding digits are treated as variables up front, and a log joins
+// the most similar cluster of its leaf, turning the differing tokens of the
+// template into wildcards. Templates only ever generalise: removing logs
+// shrinks or drops clusters but never restores a wildcard.
+type patternMiner struct {
+	root        *patternNode
+	byLog       map[LogID]*patternCluster
+	depth       int
+	similarity  float64
+	maxChildren int
+}
+
+// PatternSummary is one line of a PATTERNS reply.
+type PatternSummary struct {
+	Template string  `json:"template"`
+	Count    int     `json:"count"`
+	Examples []LogID `json:"examples"`
+}
+
+func getNewPatternMiner() *patternMiner {
+	return &patternMiner{
+		root:        getNewPatternNode(),
+		byLog:       map[LogID]*patternCluster{},
+		depth:       defaultPatternDepth,
+		similarity:  defaultPatternSimilarity,
+		maxChildren: defaultPatternMaxChildren,
+	}
+}
+
+// add puts log into the cluster its data fits best, creating one when none
+// is similar enough.
+func (m *patternMiner) add(log Log) {
+	m.remove(log)
+	tokens := patternTokens(log.Data)
+	path, leaf := m.leaf(tokens)
+	cluster := m.bestCluster(leaf, tokens)
+	if cluster == nil {
+		cluster = &patternCluster{
+			template: append([]string{}, tokens...),
+			members:  map[LogID]searchPosition{},
+			path:     path,
+		}
+		leaf.clusters = append(leaf.clusters, cluster)
+	} else {
+		for i, token := range tokens {
+			if cluster.template[i] != token {
+				cluster.template[i] = wildcard
+			}
+		}
+	}
+	cluster.members[log.ID] = getSearchPosition(log)
+	m.byLog[log.ID] = cluster
+}
+
+// remove takes log out of its cluster, dropping the cluster and any tree
+// nodes left empty.
+func (m *patternMiner) remove(log Log) {
+	cluster, found := m.byLog[log.ID]
+	if !found {
+		return
+	}
+	delete(m.byLog, log.ID)
+	delete(cluster.members, log.ID)
+	if len(cluster.members) > 0 {
+		return
+	}
+
+	nodes := []*patternNode{m.root}
+	for _, key := range cluster.path {
+		nodes = append(nodes, nodes[len(nodes)-1].children[key])
+	}
+	leaf := nodes[len(nodes)-1]
+	for i, other := range leaf.clusters {
+		if other == cluster {
+			leaf.clusters = append(leaf.clusters[:i], leaf.clusters[i+1:]...)
+			break
+		}
+	}
+	for i := len(cluster.path) - 1; i >= 0; i-- {
+		node := nodes[i+1]
+		if len(node.children) > 0 || len(node.clusters) > 0 {
+			break
+		}
+		delete(nodes[i].children, cluster.path[i])
+	}
+}
+
+// leaf walks the tree for tokens, creating nodes on the way, and returns
+// the leaf together with the keys leading to it.
+func (m *patternMiner) leaf(tokens []string) ([]string, *patternNode) {
+	path := []string{strconv.Itoa(len(tokens))}
+	for i := 0; i < m.depth && i < len(tokens); i++ {
+		path = append(path, tokens[i])
+	}
+	node := m.root
+	for i, key := range path {
+		child, found := node.children[key]
+		if !found {
+			if i > 0 && len(node.children) >= m.maxChildren {
+				key = wildcard
+				path[i] = wildcard
+				child = node.children[key]
+			}
+			if child == nil {
+				child = getNewPatternNode()
+				node.children[key] = child
+			}
+		}
+		node = child
+	}
+	return path, node
+}
+
+// bestCluster returns the cluster of leaf whose template shares the largest
+// share of tokens with tokens, preferring more general templates on ties, or
+// nil when none reaches the similarity threshold.
+func (m *patternMiner) bestCluster(leaf *patternNode, tokens []string) *patternCluster {
+	var best *patternCluster
+	bestScore, bestWildcards := -1.0, -1
+	for _, cluster := range leaf.clusters {
+		same, wildcards := 0, 0
+		for i, token := range cluster.template {
+			if token == wildcard {
+				wildcards++
+			} else if token == tokens[i] {
+				same++
+			}
+		}
+		score := 1.0
+		if len(tokens) > 0 {
+			score = float64(same) / float64(len(tokens))
+		}
+		if score > bestScore || (score == bestScore && wildcards > bestWildcards) {
+			best, bestScore, bestWildcards = cluster, score, wildcards
+		}
+	}
+	if best == nil || bestScore < m.similarity {
+		return nil
+	}
+	return best
+}
+
+// top returns the n most common templates, counting only the logs accepted
+// by accept (all of them when it is nil). Each comes with the ids of its
+// newest logs as examples.
+func (m *patternMiner) top(n int, accept func(id LogID) bool) []PatternSummary {
+	positions := map[string][]searchPosition{}
+	m.walk(m.root, func(cluster *patternCluster) {
+		template := strings.Join(cluster.template, " ")
+		for id, position := range cluster.members {
+			if accept == nil || accept(id) {
+				positions[template] = append(positions[template], position)
+			}
+		}
+	})
+	summaries := []PatternSummary{}
+	for template, members := range positions {
+		summaries = append(summaries, PatternSummary{Template: template, Count: len(members)})
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Count != summaries[j].Count {
+			return summaries[i].Count > summaries[j].Count
+		}
+		return summaries[i].Template < summaries[j].Template
+	})
+	if len(summaries) > n {
+		summaries = summaries[:n]
+	}
+	for i := range summaries {
+		members := positions[summaries[i].Template]
+		sort.Slice(members, func(a, b int) bool {
+			return members[a].before(members[b])
+		})
+		summaries[i].Examples = []LogID{}
+		for j := 0; j < len(members) && j < patternExamples; j++ {
+			summaries[i].Examples = append(summaries[i].Examples, members[j].ID)
+		}
+	}
+	return summaries
+}
+
+func (m *patternMiner) walk(node *patternNode, visit func(cluster *patternCluster)) {
+	for _, cluster := range node.clusters {
+		visit(cluster)
+	}
+	for _, child := range node.children {
+		m.walk(child, visit)
+	}
+}
+
+// patternTokens splits data on whitespace and replaces the tokens holding
+// digits, which are nearly always ids, counts or addresses, with wildcards.
+func patternTokens(data string) []string {
+	tokens := strings.Fields(data)
+	for i, token := range tokens {
+		if strings.IndexFunc(token, unicode.IsDigit) >= 0 {
+			tokens[i] = wildcard
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func Test_patternTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{"words are kept", "connection reset", []string{"connection", "reset"}},
+		{"tokens with digits are variables", "user u42 logged in from 10.0.0.1", []string{"user", "<*>", "logged", "in", "from", "<*>"}},
+		{"empty", "", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := patternTokens(tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("patternTokens() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStorage_getPatterns(t *testing.T) {
+	logs := []Log{
+		{ID: "1", Data: "user alice logged in from 10.0.0.1"},
+		{ID: "2", Data: "user bob logged in from 10.0.0.2"},
+		{ID: "3", Data: "disk full on /var"},
+		{ID: "4", Data: "user carol logged in from 10.0.0.3"},
+		{ID: "5", Data: "disk full on /tmp"},
+	}
+	tests := []struct {
+		name     string
+		capacity int
+		updates  []Log
+		query    string
+		n        int
+		want     []PatternSummary
+	}{
+		{
+			"similar lines share a template",
+			10,
+			nil,
+			"",
+			10,
+			[]PatternSummary{
+				{"user <*> logged in from <*>", 3, []LogID{"4", "2", "1"}},
+				{"disk full on <*>", 2, []LogID{"5", "3"}},
+			},
+		},
+		{
+			"top n",
+			10,
+			nil,
+			"",
+			1,
+			[]PatternSummary{{"user <*> logged in from <*>", 3, []LogID{"4", "2", "1"}}},
+		},
+		{
+			"query narrows the counts",
+			10,
+			nil,
+			"alice",
+			10,
+			[]PatternSummary{{"user <*> logged in from <*>", 1, []LogID{"1"}}},
+		},
+		{
+			"evicted logs leave their group",
+			2,
+			nil,
+			"",
+			10,
+			[]PatternSummary{
+				{"disk full on <*>", 1, []LogID{"5"}},
+				{"user <*> logged in from <*>", 1, []LogID{"4"}},
+			},
+		},
+		{
+			"updated logs move to their new group",
+			10,
+			[]Log{{ID: "3", Data: "connection reset by peer"}, {ID: "5", Data: "connection reset by peer"}},
+			"",
+			10,
+			[]PatternSummary{
+				{"user <*> logged in from <*>", 3, []LogID{"4", "2", "1"}},
+				{"connection reset by peer", 2, []LogID{"5", "3"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := getNewStore(tt.capacity)
+			for _, log := range append(append([]Log{}, logs...), tt.updates...) {
+				s.upsertLog(log.ID, log.Data)
+			}
+			if got := s.getPatterns(tt.query, tt.n); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getPatterns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSession_processPatterns(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		want     string
+	}{
+		{
+			"templates with counts and examples",
+			[]string{"ADD 1 job 17 done", "ADD 2 job 18 done", "ADD 3 job 19 failed", "PATTERNS"},
+			"2 2,1 job <*> done\r\n1 3 job <*> failed\r\n",
+		},
+		{
+			"query and count",
+			[]string{"ADD 1 job 17 done", "ADD 2 job 18 failed", "PATTERNS failed 1"},
+			"1 2 job <*> failed\r\n",
+		},
+		{
+			"nothing stored",
+			[]string{"PATTERNS"},
+			"NONE\r\n",
+		},
+		{
+			"bad count",
+			[]string{"PATTERNS job x"},
+			"ERR BAD_ARGUMENT invalid count \"x\"\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := &bytes.Buffer{}
+			session := getNewSession(getNewStore(10), output)
+			for _, command := range tt.commands {
+				session.execute(command)
+			}
+			if got := output.String(); got != tt.want {
+				t.Errorf("execute() output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,17 +3,19 @@ package main
 import "container/list"
 
 type Buffer struct {
-	list *list.List
+	list  *list.List
+	elems map[LogID]*list.Element
 }
 
 func getNewBuffer() Buffer {
 	return Buffer{
-		list: list.New(),
+		list:  list.New(),
+		elems: map[LogID]*list.Element{},
 	}
 }
 
 func (q *Buffer) Enqueue(item *LogID) {
-	q.list.PushFront(item)
+	q.elems[*item] = q.list.PushFront(item)
 }
 
 func (q *Buffer) Len() int {
@@ -26,5 +28,36 @@ func (q *Buffer) Dequeue() *LogID {
 		return nil
 	}
 	q.list.Remove(lastElem)
+	id := lastElem.Value.(*LogID)
+	if q.elems[*id] == lastElem {
+		delete(q.elems, *id)
+	}
+	return id
+}
+
+// Remove takes id out of the queue wherever it is.
+func (q *Buffer) Remove(id LogID) {
+	if elem, found := q.elems[id]; found {
+		q.list.Remove(elem)
+		delete(q.elems, id)
+	}
+}
+
+func (q *Buffer) Peek() *LogID {
+	lastElem := q.list.Back()
+	if lastElem == nil {
+		return nil
+	}
 	return lastElem.Value.(*LogID)
 }
+
+// Each calls handle with every item from the oldest to the newest, stopping
+// at the first error.
+func (q *Buffer) Each(handle func(item *LogID) error) error {
+	for elem := q.list.Back(); elem != nil; elem = elem.Prev() {
+		if err := handle(elem.Value.(*LogID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
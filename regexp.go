@@ -0,0 +1,172 @@
+package main
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"sort"
+)
+
+// searchRegexp returns the logs whose data matches pattern. Only the
+// candidates of regexpCandidates are checked against the pattern; when they
+// can't be narrowed every stored log is scanned.
+func (s *Storage) searchRegexp(pattern *regexp.Regexp, limit int, opts SearchOptions) []Log {
+	s.expire()
+	match := s.patternMatcher(pattern, opts)
+	docs, history, narrowed := s.regexpCandidates(pattern.String())
+	if !narrowed {
+		return s.collectLogs(s.allDocs(), limit, opts, match)
+	}
+	if opts.IncludeHistory {
+		docs = unionEntries(docs, history)
+	}
+	return s.collectLogs(docs, limit, opts, match)
+}
+
+// regexpCandidates returns the docs, and the old versions, that hold every
+// word and, with the trigram index enabled, every trigram of the literal
+// runs that any match of pattern must contain. The last result is false
+// when neither narrows the search.
+func (s *Storage) regexpCandidates(pattern string) ([]DocID, []DocID, bool) {
+	var docs, history []DocID
+	narrowed := false
+	narrow := func(more, moreHistory []DocID) {
+		if narrowed {
+			docs = intersectEntries(docs, more)
+			history = intersectEntries(history, moreHistory)
+		} else {
+			docs, history = more, moreHistory
+		}
+		narrowed = true
+	}
+	for _, term := range requiredTerms(pattern, s.analyzer) {
+		narrow(s.lookupKey(term), s.history.index.getByKey(term))
+	}
+	for _, literal := range requiredLiterals(pattern) {
+		more, indexed := s.lookupSubstring(literal)
+		if !indexed {
+			continue
+		}
+		moreHistory, _ := s.history.index.getBySubstring(literal)
+		narrow(more, moreHistory)
+	}
+	return docs, history, narrowed
+}
+
+// patternMatcher accepts logs whose data matches pattern, or with
+// IncludeHistory one of whose old versions does.
+func (s *Storage) patternMatcher(pattern *regexp.Regexp, opts SearchOptions) func(log Log) bool {
+	return func(log Log) bool {
+		if pattern.MatchString(log.Data) {
+			return true
+		}
+		if opts.IncludeHistory {
+			for _, version := range s.history.get(log.ID) {
+				if pattern.MatchString(version.Data) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+// allDocs returns every stored doc, oldest first like a posting list.
+func (s *Storage) allDocs() []DocID {
+	docs := []DocID{}
+	for doc := range s.docs {
+		docs = append(docs, doc)
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i] < docs[j]
+	})
+	return docs
+}
+
+// requiredTerms returns index terms that any text matching pattern must
+// contain. It looks at the literal runs of a top-level concatenation: a
+// term inside a literal is required when the literal itself separates it
+// from its neighbours, or the run is anchored to the start or end of text.
+func requiredTerms(pattern string, analyzer Analyzer) []string {
+	nodes := concatNodes(pattern)
+	if nodes == nil {
+		return nil
+	}
+
+	terms := []string{}
+	seen := map[string]struct{}{}
+	for i := 0; i < len(nodes); i++ {
+		if !isPlainLiteral(nodes[i]) {
+			continue
+		}
+		start := i
+		literal := ""
+		for ; i < len(nodes) && isPlainLiteral(nodes[i]); i++ {
+			literal += string(nodes[i].Rune)
+		}
+		anchoredStart := start > 0 && nodes[start-1].Op == syntax.OpBeginText
+		anchoredEnd := i < len(nodes) && nodes[i].Op == syntax.OpEndText
+
+		tokens := analyzer(literal)
+		for j, token := range tokens {
+			if j == 0 && token.Start == 0 && !anchoredStart {
+				continue
+			}
+			if j == len(tokens)-1 && token.End == len(literal) && !anchoredEnd {
+				continue
+			}
+			if _, found := seen[token.Term]; !found {
+				seen[token.Term] = struct{}{}
+				terms = append(terms, token.Term)
+			}
+		}
+	}
+	return terms
+}
+
+// requiredLiterals returns the literal runs of a top-level concatenation
+// of pattern that are long enough to have trigrams. Case folded literals
+// count too, since the trigram index ignores case.
+func requiredLiterals(pattern string) []string {
+	nodes := concatNodes(pattern)
+	literals := []string{}
+	for i := 0; i < len(nodes); i++ {
+		literal := []rune{}
+		for ; i < len(nodes) && nodes[i].Op == syntax.OpLiteral; i++ {
+			literal = append(literal, nodes[i].Rune...)
+		}
+		if len(literal) >= gramSize {
+			literals = append(literals, string(literal))
+		}
+	}
+	return literals
+}
+
+// concatNodes returns the parts of pattern when it is a concatenation, the
+// whole pattern as the only part otherwise, and nil when it doesn't parse.
+func concatNodes(pattern string) []*syntax.Regexp {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	re = re.Simplify()
+	if re.Op == syntax.OpConcat {
+		return re.Sub
+	}
+	return []*syntax.Regexp{re}
+}
+
+func isPlainLiteral(re *syntax.Regexp) bool {
+	return re.Op == syntax.OpLiteral && re.Flags&syntax.FoldCase == 0
+}
+
+// regexpMatches returns the matched ranges of data as tokens so they can be
+// highlighted like word matches.
+func regexpMatches(pattern *regexp.Regexp, data string) []Token {
+	matches := []Token{}
+	for _, span := range pattern.FindAllStringIndex(data, -1) {
+		if span[1] > span[0] {
+			matches = append(matches, Token{Term: data[span[0]:span[1]], Start: span[0], End: span[1]})
+		}
+	}
+	return matches
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func Test_requiredTerms(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  string
+		analyzer Analyzer
+		want     []string
+	}{
+		{"bare word may be part of a longer token", "upstream", analyzeWhitespace, []string{}},
+		{"anchored word", "^upstream$", analyzeWhitespace, []string{"upstream"}},
+		{"word between spaces", `5\d\d .* upstream timeout .*`, analyzeWhitespace, []string{"upstream", "timeout"}},
+		{"several words", "connection reset by peer", analyzeWhitespace, []string{"reset", "by"}},
+		{"standard analyzer splits on punctuation", `host=db1 error=timeout\d`, analyzeStandard, []string{"db1", "error"}},
+		{"standard analyzer lowercases", "^Error: disk", analyzeStandard, []string{"error"}},
+		{"alternation", "a b c|d e f", analyzeWhitespace, []string{}},
+		{"case folded literal", "(?i)connection reset by peer", analyzeWhitespace, []string{}},
+		{"invalid pattern", "(", analyzeWhitespace, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requiredTerms(tt.pattern, tt.analyzer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requiredTerms() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_requiredLiterals(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		want    []string
+	}{
+		{"literal runs", `5\d\d .* upstream`, []string{" upstream"}},
+		{"short runs are left out", `ab\d+cde`, []string{"cde"}},
+		{"case folded literal", "(?i)Timeout", []string{"TIMEOUT"}},
+		{"alternation", "abc|def", []string{}},
+		{"invalid pattern", "(", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requiredLiterals(tt.pattern); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requiredLiterals() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStorage_regexpCandidates(t *testing.T) {
+	for _, trigrams := range []bool{false, true} {
+		s := getNewStore(10)
+		if trigrams {
+			s.enableTrigrams()
+		}
+		s.upsertLog("1", "GET /api/v1 502 upstream timeout")
+		s.upsertLog("2", "GET /api/v1 404 not found")
+		s.upsertLog("3", "GET /api/v2 504 upstream timeout")
+		docs, _, narrowed := s.regexpCandidates(`5\d\d .* upstream`)
+		if narrowed != trigrams || (trigrams && len(docs) != 2) {
+			t.Errorf("regexpCandidates() with trigrams %v = %v, %v", trigrams, docs, narrowed)
+		}
+	}
+}
+
+func TestStorage_searchRegexp(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		opts    SearchOptions
+		want    []LogID
+	}{
+		{"index assisted", `5\d\d.* upstream timeout`, SearchOptions{}, []LogID{"3", "1"}},
+		{"full scan", `^GET /api`, SearchOptions{}, []LogID{"3", "2", "1"}},
+		{"inside tokens", `stream`, SearchOptions{}, []LogID{"3", "1"}},
+		{"no match", `^POST`, SearchOptions{}, nil},
+		{"history", `^GET /api/v1 404`, SearchOptions{IncludeHistory: true}, []LogID{"2"}},
+	}
+	for _, tt := range tests {
+		for _, trigrams := range []bool{false, true} {
+			s := getNewStore(10)
+			if trigrams {
+				s.enableTrigrams()
+			}
+			s.upsertLog("1", "GET /api/v1 502 upstream timeout after 30s")
+			s.upsertLog("2", "GET /api/v1 404 not found")
+			s.upsertLog("2", "GET /api/v1 200 ok")
+			s.upsertLog("3", "GET /api/v2 504 upstream timeout after 60s")
+			var got []LogID
+			for _, log := range s.searchRegexp(regexp.MustCompile(tt.pattern), 10, tt.opts) {
+				got = append(got, log.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s: searchRegexp() with trigrams %v = %v, want %v", tt.name, trigrams, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestSession_searchRegexp(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		want     string
+	}{
+		{
+			"regexp with spaces",
+			[]string{"ADD 1 502 bad upstream", "ADD 2 200 ok", "SEARCH /5\\d\\d .* upstream/ 20"},
+			"1\r\n",
+		},
+		{
+			"regexp with options",
+			[]string{"ADD 1 502 bad upstream", "SEARCH /up.*m/ 1 HIGHLIGHT"},
+			"1 502 bad [upstream]\r\n",
+		},
+		{
+			"slash inside regexp",
+			[]string{"ADD 1 GET /api/v1", "SEARCH /GET /api/ 1"},
+			"1\r\n",
+		},
+		{
+			"invalid regexp",
+			[]string{"SEARCH /(/ 1"},
+			"ERR BAD_ARGUMENT invalid regexp: error parsing regexp: missing closing ): `(`\r\n",
+		},
+		{
+			"missing limit",
+			[]string{"SEARCH /abc/"},
+			"ERR BAD_ARGUMENT " + searchUsage + "\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := &bytes.Buffer{}
+			session := getNewSession(getNewStore(3), output)
+			for _, command := range tt.commands {
+				session.execute(command)
+			}
+			if got := output.String(); got != tt.want {
+				t.Errorf("execute() output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
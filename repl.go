@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	replPrompt  = "> "
+	historyFile = ".log-search_history"
+)
+
+var replCommands = []string{"ABORT", "ADD", "ADDAT", "APPEND", "BEGIN", "CHECK", "COMMIT", "COMPACT", "END", "EXPORT", "FLUSH", "HISTORY", "IMPORT", "INFO", "PATTERNS", "PROTO", "RULE", "SEARCH", "SET", "STATS", "TERM"}
+
+var ruleSubcommands = []string{"ADD", "DEL", "LIST"}
+
+// repl reads commands from input and prints each response right away until
+// END or the end of input. On a terminal it supports line editing, history
+// kept in historyPath and tab completion of commands and indexed words.
+func repl(store *Storage, input io.Reader, output io.Writer, historyPath string) error {
+	if f, ok := input.(*os.File); ok {
+		if restore, err := makeRaw(f.Fd()); err == nil {
+			defer restore()
+			return interactiveRepl(store, f, output, historyPath)
+		}
+	}
+
+	session := getNewSession(store, output)
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	for {
+		output.Write([]byte(replPrompt))
+		if !scanner.Scan() {
+			output.Write([]byte("\r\n"))
+			return scanner.Err()
+		}
+		command := strings.TrimSpace(scanner.Text())
+		if command == "" {
+			continue
+		}
+		processReplCommand(session, command)
+		if command == "END" {
+			return nil
+		}
+	}
+}
+
+func interactiveRepl(store *Storage, input io.Reader, output io.Writer, historyPath string) error {
+	session := getNewSession(store, output)
+	session.highlighter = ansiHighlighter
+	editor := getNewLineEditor(input, output, replPrompt)
+	editor.complete = func(line string) []string {
+		return getReplCompletions(store, line)
+	}
+	history := loadHistory(historyPath)
+	for _, line := range history {
+		editor.addHistory(line)
+	}
+	for {
+		line, err := editor.readLine()
+		if err == errInterrupted {
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		command := strings.TrimSpace(line)
+		if command == "" {
+			continue
+		}
+		editor.addHistory(command)
+		appendHistory(historyPath, command)
+		processReplCommand(session, command)
+		if command == "END" {
+			return nil
+		}
+	}
+}
+
+// processReplCommand runs a command and, for SEARCH in text mode, prints
+// the full log lines instead of just their ids.
+func processReplCommand(session *Session, command string) {
+	response := session.run(command)
+	if session.proto != ProtoText || response.Command != "SEARCH" || len(response.Results) == 0 {
+		session.write(response)
+		return
+	}
+	for _, result := range response.Results {
+		session.output.Write([]byte(formatReplResult(result) + "\r\n"))
+	}
+	// The ids take one line, or one per result with HIGHLIGHT, and a paged
+	// search adds its CURSOR line after them.
+	idLines := 1
+	if response.Results[0].Highlight != "" {
+		idLines = len(response.Results)
+	}
+	for _, line := range response.text[idLines:] {
+		session.output.Write([]byte(line + "\r\n"))
+	}
+}
+
+func formatReplResult(result Result) string {
+	data := result.Data
+	if result.Highlight != "" {
+		data = result.Highlight
+	}
+	return fmt.Sprintf("%-8s %s  %s", result.ID, result.CreatedAt.Format(time.RFC3339), data)
+}
+
+// getReplCompletions returns the candidates for the last word of line:
+// command names first, then RULE subcommands or words in the index.
+func getReplCompletions(store *Storage, line string) []string {
+	words := strings.Fields(line)
+	if len(words) == 0 || strings.HasSuffix(line, " ") {
+		words = append(words, "")
+	}
+	prefix := words[len(words)-1]
+	if len(words) == 1 {
+		return filterByPrefix(replCommands, strings.ToUpper(prefix))
+	}
+	if words[0] == "RULE" && len(words) == 2 {
+		return filterByPrefix(ruleSubcommands, strings.ToUpper(prefix))
+	}
+	return store.keysWithPrefix(prefix)
+}
+
+func filterByPrefix(options []string, prefix string) []string {
+	matches := []string{}
+	for _, option := range options {
+		if strings.HasPrefix(option, prefix) {
+			matches = append(matches, option)
+		}
+	}
+	return matches
+}
+
+func loadHistory(path string) []string {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	lines := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func appendHistory(path, line string) {
+	if path == "" {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.WriteString(line + "\n")
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A segment file holds an immutable part of the store, laid out as
+//
+//	header      magic, doc and term counts, section offsets
+//	doc table   fixed size entries: doc id, version, timestamps, the
+//	            offsets of the log id and data, the sequence number and
+//	            the offset of the fields, query encoded
+//	term table  fixed size entries sorted by key: the key offset and the
+//	            offset and length of its posting list
+//	postings    ascending doc ordinals, uint32 each
+//	strings     log ids, log data, fields and keys
+//
+// All numbers are little endian. Postings refer to docs by their position in
+// the doc table, so a per-segment bitmap is enough to mark them deleted.
+var segmentMagic = [8]byte{'L', 'S', 'S', 'E', 'G', '0', '0', '3'}
+
+const (
+	segmentHeaderSize = 48
+	segmentDocSize    = 72
+	segmentTermSize   = 24
+)
+
+// gramKeyPrefix marks trigram keys in the term table, keeping them apart
+// from the words.
+const gramKeyPrefix = "\x00"
+
+type segment struct {
+	path     string
+	data     []byte
+	docs     int
+	terms    int
+	docsOff  int
+	termsOff int
+	deleted  []uint64
+	live     int
+	// merging keeps the segment mapped while a compaction reads it.
+	merging bool
+}
+
+// writeSegment stores logs with the index keys of each, as returned by keys,
+// into a new segment file at path.
+func writeSegment(path string, logs []Log, keys func(log Log) []string) error {
+	postings := map[string][]uint32{}
+	for ordinal, log := range logs {
+		for _, key := range keys(log) {
+			postings[key] = append(postings[key], uint32(ordinal))
+		}
+	}
+	return writeSegmentPostings(path, logs, postings)
+}
+
+// writeSegmentPostings writes a segment whose posting lists, holding
+// ascending positions in logs, are already built.
+func writeSegmentPostings(path string, logs []Log, postings map[string][]uint32) error {
+	sortedKeys := make([]string, 0, len(postings))
+	entries := 0
+	for key, ordinals := range postings {
+		sortedKeys = append(sortedKeys, key)
+		entries += len(ordinals)
+	}
+	sort.Strings(sortedKeys)
+
+	docsOff := segmentHeaderSize
+	termsOff := docsOff + len(logs)*segmentDocSize
+	postOff := termsOff + len(sortedKeys)*segmentTermSize
+	stringsOff := postOff + entries*4
+
+	header := make([]byte, segmentHeaderSize)
+	copy(header, segmentMagic[:])
+	binary.LittleEndian.PutUint32(header[8:], uint32(len(logs)))
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(sortedKeys)))
+	binary.LittleEndian.PutUint64(header[16:], uint64(docsOff))
+	binary.LittleEndian.PutUint64(header[24:], uint64(termsOff))
+	binary.LittleEndian.PutUint64(header[32:], uint64(postOff))
+	binary.LittleEndian.PutUint64(header[40:], uint64(stringsOff))
+
+	docTable := &bytes.Buffer{}
+	termTable := &bytes.Buffer{}
+	postingList := &bytes.Buffer{}
+	stringArea := &bytes.Buffer{}
+	addString := func(value string) uint64 {
+		offset := uint64(stringsOff + stringArea.Len())
+		stringArea.WriteString(value)
+		return offset
+	}
+	entry := make([]byte, segmentDocSize)
+	for _, log := range logs {
+		binary.LittleEndian.PutUint32(entry[0:], uint32(log.DocID))
+		binary.LittleEndian.PutUint32(entry[4:], uint32(log.Version))
+		binary.LittleEndian.PutUint64(entry[8:], uint64(log.CreatedAt.UnixNano()))
+		binary.LittleEndian.PutUint64(entry[16:], uint64(log.UpdatedAt.UnixNano()))
+		binary.LittleEndian.PutUint64(entry[24:], addString(string(log.ID)))
+		binary.LittleEndian.PutUint64(entry[32:], addString(log.Data))
+		binary.LittleEndian.PutUint32(entry[40:], uint32(len(log.ID)))
+		binary.LittleEndian.PutUint32(entry[44:], uint32(len(log.Data)))
+		binary.LittleEndian.PutUint64(entry[48:], log.Seq)
+		fields := encodeFields(log.Fields)
+		binary.LittleEndian.PutUint64(entry[56:], addString(fields))
+		binary.LittleEndian.PutUint32(entry[64:], uint32(len(fields)))
+		docTable.Write(entry)
+	}
+	term := make([]byte, segmentTermSize)
+	ordinal := make([]byte, 4)
+	for _, key := range sortedKeys {
+		binary.LittleEndian.PutUint64(term[0:], addString(key))
+		binary.LittleEndian.PutUint64(term[8:], uint64(postOff+postingList.Len()))
+		binary.LittleEndian.PutUint32(term[16:], uint32(len(key)))
+		binary.LittleEndian.PutUint32(term[20:], uint32(len(postings[key])))
+		termTable.Write(term)
+		for _, doc := range postings[key] {
+			binary.LittleEndian.PutUint32(ordinal, doc)
+			postingList.Write(ordinal)
+		}
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(f)
+	for _, section := range [][]byte{header, docTable.Bytes(), termTable.Bytes(), postingList.Bytes(), stringArea.Bytes()} {
+		writer.Write(section)
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// openSegment maps the segment file at path. Every doc starts out live.
+func openSegment(path string) (*segment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < segmentHeaderSize {
+		return nil, fmt.Errorf("%s: not a segment file", path)
+	}
+	data, err := mapFile(f, int(info.Size()))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(data[:8], segmentMagic[:]) {
+		unmapFile(data)
+		return nil, fmt.Errorf("%s: not a segment file", path)
+	}
+	docs := int(binary.LittleEndian.Uint32(data[8:]))
+	s := &segment{
+		path:     path,
+		data:     data,
+		docs:     docs,
+		terms:    int(binary.LittleEndian.Uint32(data[12:])),
+		docsOff:  int(binary.LittleEndian.Uint64(data[16:])),
+		termsOff: int(binary.LittleEndian.Uint64(data[24:])),
+		deleted:  make([]uint64, (docs+63)/64),
+		live:     docs,
+	}
+	if s.docsOff+s.docs*segmentDocSize > len(data) || s.termsOff+s.terms*segmentTermSize > len(data) {
+		s.close()
+		return nil, fmt.Errorf("%s: truncated segment file", path)
+	}
+	return s, nil
+}
+
+func (s *segment) close() error {
+	if s.data == nil {
+		return nil
+	}
+	err := unmapFile(s.data)
+	s.data = nil
+	return err
+}
+
+func (s *segment) string(offset uint64, length uint32) string {
+	return string(s.data[offset : offset+uint64(length)])
+}
+
+// log reads the doc at ordinal.
+func (s *segment) log(ordinal uint32) Log {
+	entry := s.data[s.docsOff+int(ordinal)*segmentDocSize:]
+	// Fields were checked when they were encoded.
+	fields, _ := decodeFields(s.string(binary.LittleEndian.Uint64(entry[56:]), binary.LittleEndian.Uint32(entry[64:])))
+	return Log{
+		ID:        LogID(s.string(binary.LittleEndian.Uint64(entry[24:]), binary.LittleEndian.Uint32(entry[40:]))),
+		DocID:     DocID(binary.LittleEndian.Uint32(entry[0:])),
+		Data:      s.string(binary.LittleEndian.Uint64(entry[32:]), binary.LittleEndian.Uint32(entry[44:])),
+		CreatedAt: time.Unix(0, int64(binary.LittleEndian.Uint64(entry[8:]))),
+		UpdatedAt: time.Unix(0, int64(binary.LittleEndian.Uint64(entry[16:]))),
+		Version:   int(binary.LittleEndian.Uint32(entry[4:])),
+		Seq:       binary.LittleEndian.Uint64(entry[48:]),
+		Fields:    fields,
+	}
+}
+
+func (s *segment) docID(ordinal uint32) DocID {
+	return DocID(binary.LittleEndian.Uint32(s.data[s.docsOff+int(ordinal)*segmentDocSize:]))
+}
+
+func (s *segment) key(term int) string {
+	entry := s.data[s.termsOff+term*segmentTermSize:]
+	return s.string(binary.LittleEndian.Uint64(entry[0:]), binary.LittleEndian.Uint32(entry[16:]))
+}
+
+// findKey returns the position of the first key in the term table that is
+// not less than key.
+func (s *segment) findKey(key string) int {
+	return sort.Search(s.terms, func(term int) bool {
+		return s.key(term) >= key
+	})
+}
+
+// ordinals returns the whole posting list of the term at position term,
+// deleted docs included.
+func (s *segment) ordinals(term int) []uint32 {
+	entry := s.data[s.termsOff+term*segmentTermSize:]
+	offset := int(binary.LittleEndian.Uint64(entry[8:]))
+	count := int(binary.LittleEndian.Uint32(entry[20:]))
+	ordinals := make([]uint32, count)
+	for i := range ordinals {
+		ordinals[i] = binary.LittleEndian.Uint32(s.data[offset+i*4:])
+	}
+	return ordinals
+}
+
+// postings returns the live docs of the term at position term.
+func (s *segment) postings(term int) []DocID {
+	docs := []DocID{}
+	for _, ordinal := range s.ordinals(term) {
+		if !s.isDeleted(ordinal) {
+			docs = append(docs, s.docID(ordinal))
+		}
+	}
+	return docs
+}
+
+func (s *segment) getByKey(key string) []DocID {
+	term := s.findKey(key)
+	if term == s.terms || s.key(term) != key {
+		return nil
+	}
+	return s.postings(term)
+}
+
+// getBySubstring returns the live docs holding every trigram of text.
+func (s *segment) getBySubstring(text string) []DocID {
+	grams := getTrigrams(text)
+	var docs []DocID
+	for i, gram := range grams {
+		entries := s.getByKey(gramKeyPrefix + gram)
+		if i == 0 {
+			docs = entries
+		} else {
+			docs = intersectEntries(docs, entries)
+		}
+		if len(docs) == 0 {
+			break
+		}
+	}
+	return docs
+}
+
+// keysWithPrefix returns the words starting with prefix that still have a
+// live doc, in order.
+func (s *segment) keysWithPrefix(prefix string) []string {
+	keys := []string{}
+	for term := s.findKey(prefix); term < s.terms; term++ {
+		key := s.key(term)
+		if !strings.HasPrefix(key, prefix) {
+			break
+		}
+		if !strings.HasPrefix(key, gramKeyPrefix) && len(s.postings(term)) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (s *segment) isDeleted(ordinal uint32) bool {
+	return s.deleted[ordinal/64]&(1<<(ordinal%64)) != 0
+}
+
+// delete marks the doc at ordinal as deleted in the segment bitmap.
+func (s *segment) delete(ordinal uint32) {
+	if !s.isDeleted(ordinal) {
+		s.deleted[ordinal/64] |= 1 << (ordinal % 64)
+		s.live--
+	}
+}
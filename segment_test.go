@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_writeSegment(t *testing.T) {
+	createdAt := time.Unix(0, 1714564800000000000)
+	logs := []Log{
+		{ID: "a", DocID: 4, Data: "disk full", CreatedAt: createdAt, UpdatedAt: createdAt, Version: 1},
+		{ID: "b", DocID: 9, Data: "disk ok", CreatedAt: createdAt, UpdatedAt: createdAt.Add(time.Second), Version: 3, Fields: map[string]string{"host": "db1"}},
+	}
+	path := filepath.Join(t.TempDir(), "000001.seg")
+	if err := writeSegment(path, logs, func(log Log) []string { return getWordsFromData(log.Data) }); err != nil {
+		t.Fatal(err)
+	}
+	seg, err := openSegment(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seg.close()
+
+	for ordinal, want := range logs {
+		if got := seg.log(uint32(ordinal)); !reflect.DeepEqual(got, want) {
+			t.Errorf("log(%d) = %+v, want %+v", ordinal, got, want)
+		}
+	}
+	tests := []struct {
+		key  string
+		want []DocID
+	}{
+		{"disk", []DocID{4, 9}},
+		{"ok", []DocID{9}},
+		{"dis", nil},
+		{"zzz", nil},
+	}
+	for _, tt := range tests {
+		if got := seg.getByKey(tt.key); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("getByKey(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+	if got := seg.keysWithPrefix("d"); !reflect.DeepEqual(got, []string{"disk"}) {
+		t.Errorf("keysWithPrefix() = %v", got)
+	}
+
+	seg.delete(0)
+	if got := seg.getByKey("disk"); !reflect.DeepEqual(got, []DocID{9}) {
+		t.Errorf("getByKey() after delete = %v, want [9]", got)
+	}
+	if got := seg.keysWithPrefix("f"); len(got) != 0 {
+		t.Errorf("keysWithPrefix() of deleted docs = %v", got)
+	}
+}
+
+func Test_openSegment(t *testing.T) {
+	truncated := make([]byte, segmentHeaderSize)
+	copy(truncated, segmentMagic[:])
+	binary.LittleEndian.PutUint32(truncated[8:], 5)
+	binary.LittleEndian.PutUint64(truncated[16:], segmentHeaderSize)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"wrong magic", bytes.Repeat([]byte{1}, segmentHeaderSize)},
+		{"truncated", truncated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "000001.seg")
+			os.WriteFile(path, tt.data, 0644)
+			if _, err := openSegment(path); err == nil {
+				t.Errorf("openSegment() should fail")
+			}
+		})
+	}
+}
+
+func TestStorage_segments(t *testing.T) {
+	s := getNewStore(4)
+	s.enableTrigrams()
+	if err := s.enableSegments(t.TempDir(), 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	s.upsertLog("1", "disk full on db1")
+	s.upsertLogs([]Log{{ID: "2", Data: "ReadTimeoutException on db2", Fields: map[string]string{"host": "db2"}}})
+	if n, err := s.flush(); n != 2 || err != nil {
+		t.Fatalf("flush() = %d, %v, want 2", n, err)
+	}
+	s.upsertLog("3", "disk ok on db3")
+	if len(s.logsStorage) != 1 || len(s.segments.list) != 1 {
+		t.Fatalf("flush should leave only fresh logs in memory")
+	}
+
+	ids := func(logs []Log) []LogID {
+		got := []LogID{}
+		for _, log := range logs {
+			got = append(got, log.ID)
+		}
+		return got
+	}
+	if got := ids(s.getLogsByWord("disk", 5)); !reflect.DeepEqual(got, []LogID{"3", "1"}) {
+		t.Errorf("search across segments = %v, want [3 1]", got)
+	}
+	if got := ids(s.searchSubstring("timeout", 5, SearchOptions{})); !reflect.DeepEqual(got, []LogID{"2"}) {
+		t.Errorf("substring search across segments = %v, want [2]", got)
+	}
+	if got := ids(s.getLogsByWord("{host=db2}", 5)); !reflect.DeepEqual(got, []LogID{"2"}) {
+		t.Errorf("field search across segments = %v, want [2]", got)
+	}
+	if log, _ := s.getLogById("2"); log.Fields["host"] != "db2" {
+		t.Errorf("getLogById() of a flushed log = %+v, want its fields", log)
+	}
+	if got := s.keysWithPrefix("db"); !reflect.DeepEqual(got, []string{"db1", "db2", "db3"}) {
+		t.Errorf("keysWithPrefix() = %v", got)
+	}
+
+	s.upsertLog("1", "disk replaced")
+	if got := ids(s.getLogsByWord("full", 5)); len(got) != 0 {
+		t.Errorf("updated log still found through its segment: %v", got)
+	}
+	if got, _ := s.getHistory("1"); len(got) != 2 || got[0].Data != "disk full on db1" {
+		t.Errorf("getHistory() of a flushed log = %v", got)
+	}
+
+	s.upsertLog("4", "four")
+	s.upsertLog("5", "five")
+	if _, err := s.getLogById("1"); err == nil {
+		t.Errorf("the oldest flushed log should be evicted")
+	}
+	if len(s.segments.list) != 1 {
+		t.Fatalf("a segment with live logs should be kept")
+	}
+	s.upsertLog("6", "six")
+	if len(s.segments.list) != 0 {
+		t.Errorf("a segment without live logs should be dropped")
+	}
+	if files, _ := filepath.Glob(filepath.Join(s.segments.dir, "*"+segmentExt)); len(files) != 0 {
+		t.Errorf("dropped segment files left behind: %v", files)
+	}
+}
+
+func TestStorage_enableSegmentsLocked(t *testing.T) {
+	dir := t.TempDir()
+	first := getNewStore(10)
+	if err := first.enableSegments(dir, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	first.upsertLog("1", "disk full")
+	if _, err := first.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := getNewStore(10).enableSegments(dir, 0, 0); err == nil {
+		t.Errorf("enableSegments() should refuse a directory in use")
+	}
+	if got := first.getLogsByWord("disk", 5); len(got) != 1 {
+		t.Errorf("segments of the first store were removed, search found %d logs", len(got))
+	}
+}
+
+func TestStorage_maybeFlush(t *testing.T) {
+	s := getNewStore(10)
+	if err := s.enableSegments(t.TempDir(), 2, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	s.upsertLog("1", "hello")
+	if len(s.logsStorage) != 1 {
+		t.Fatalf("flushed before reaching the flush size")
+	}
+	s.upsertLog("2", "hello")
+	if len(s.logsStorage) != 0 {
+		t.Errorf("not flushed at the flush size")
+	}
+	s.upsertLog("3", "hello")
+	s.maybeFlush(time.Now().Add(2 * time.Minute))
+	if len(s.logsStorage) != 0 || len(s.segments.list) != 2 {
+		t.Errorf("not flushed after the flush interval")
+	}
+}
+
+func TestSession_processFlush(t *testing.T) {
+	output := &bytes.Buffer{}
+	session := getNewSession(getNewStore(10), output)
+	session.execute("FLUSH")
+	if got := output.String(); got != "ERR BAD_STATE segments are disabled\r\n" {
+		t.Errorf("FLUSH output = %q", got)
+	}
+
+	output.Reset()
+	store := getNewStore(10)
+	store.enableSegments(t.TempDir(), 0, 0)
+	session = getNewSession(store, output)
+	for _, command := range []string{"ADD 1 hello", "ADD 2 hello", "FLUSH", "SEARCH hello 5", "FLUSH"} {
+		session.execute(command)
+	}
+	if got := output.String(); got != "OK 2\r\n2 1\r\nOK 0\r\n" {
+		t.Errorf("execute() output = %q", got)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	segmentsDir          = "segments"
+	segmentExt           = ".seg"
+	segmentsLockFile     = "LOCK"
+	defaultFlushSize     = 5000
+	defaultFlushInterval = 30 * time.Second
+)
+
+// segmentRef is where a flushed log lives.
+type segmentRef struct {
+	segment *segment
+	ordinal uint32
+}
+
+// segmentSet holds the on-disk segments of a store. The in-memory index and
+// logsStorage act as the segment for fresh writes; flushing moves their logs
+// into a new immutable segment file and keeps only its location in memory.
+type segmentSet struct {
+	dir string
+	// lock keeps other processes from using dir while the store does.
+	lock      *os.File
+	list      []*segment
+	locations map[LogID]segmentRef
+	seq       int
+	// flushSize is how many fresh logs trigger a flush and flushInterval how
+	// long they may wait for one, 0 disables either.
+	flushSize     int
+	flushInterval time.Duration
+	lastFlush     time.Time
+	policy        mergePolicy
+	merge         mergeConfig
+	stats         compactionStats
+}
+
+// enableSegments stores flushed logs as segment files in dir. Segment files
+// only make sense to the process that wrote them, so leftovers from an
+// earlier run are removed, once dir is locked so that the segments of
+// another running process are left alone.
+func (s *Storage) enableSegments(dir string, flushSize int, flushInterval time.Duration) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	lock, err := lockFile(filepath.Join(dir, segmentsLockFile))
+	if err != nil {
+		return fmt.Errorf("segments directory %s is in use by another process: %v", dir, err)
+	}
+	stale, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		return err
+	}
+	for _, path := range stale {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	s.segments = &segmentSet{
+		dir:           dir,
+		lock:          lock,
+		locations:     map[LogID]segmentRef{},
+		flushSize:     flushSize,
+		flushInterval: flushInterval,
+		lastFlush:     s.now(),
+		policy:        mergeTiered,
+		merge:         mergeConfig{factor: defaultMergeFactor, maxDeleted: defaultMaxDeleted},
+	}
+	return nil
+}
+
+// flush writes every fresh log to a new segment and drops them from memory.
+// It returns how many logs were flushed.
+func (s *Storage) flush() (int, error) {
+	if s.segments == nil {
+		return 0, fmt.Errorf("segments are disabled")
+	}
+	s.segments.lastFlush = s.now()
+	if len(s.logsStorage) == 0 {
+		return 0, nil
+	}
+	logs := make([]Log, 0, len(s.logsStorage))
+	for _, log := range s.logsStorage {
+		logs = append(logs, log)
+	}
+	sort.Slice(logs, func(i, j int) bool {
+		return logs[i].DocID < logs[j].DocID
+	})
+
+	s.segments.seq++
+	path := filepath.Join(s.segments.dir, fmt.Sprintf("%06d%s", s.segments.seq, segmentExt))
+	err := writeSegment(path, logs, func(log Log) []string {
+		keys := append([]string{}, s.index.entryToKeys[log.DocID]...)
+		if s.index.grams != nil {
+			for _, gram := range s.index.grams.entryToKeys[log.DocID] {
+				keys = append(keys, gramKeyPrefix+gram)
+			}
+		}
+		return keys
+	})
+	if err != nil {
+		return 0, err
+	}
+	seg, err := openSegment(path)
+	if err != nil {
+		return 0, err
+	}
+	s.segments.list = append(s.segments.list, seg)
+	for ordinal, log := range logs {
+		s.index.deletedByLogId(log.DocID)
+		delete(s.logsStorage, log.ID)
+		s.metrics.storedBytes -= int64(len(log.Data))
+		s.segments.locations[log.ID] = segmentRef{seg, uint32(ordinal)}
+	}
+	return len(logs), nil
+}
+
+// maybeFlush flushes once the fresh logs reach the flush size, or when the
+// flush interval has passed since the last flush.
+func (s *Storage) maybeFlush(now time.Time) error {
+	if s.segments == nil || len(s.logsStorage) == 0 {
+		return nil
+	}
+	full := s.segments.flushSize > 0 && len(s.logsStorage) >= s.segments.flushSize
+	due := s.segments.flushInterval > 0 && now.Sub(s.segments.lastFlush) >= s.segments.flushInterval
+	if !full && !due {
+		return nil
+	}
+	_, err := s.flush()
+	return err
+}
+
+// getFlushedLog reads a log back from its segment.
+func (s *Storage) getFlushedLog(id LogID) (Log, bool) {
+	if s.segments == nil {
+		return Log{}, false
+	}
+	ref, found := s.segments.locations[id]
+	if !found {
+		return Log{}, false
+	}
+	return ref.segment.log(ref.ordinal), true
+}
+
+// forgetFlushed marks a flushed log deleted in its segment bitmap, removing
+// the segment file once none of its logs is left.
+func (s *Storage) forgetFlushed(id LogID) {
+	if s.segments == nil {
+		return
+	}
+	ref, found := s.segments.locations[id]
+	if !found {
+		return
+	}
+	delete(s.segments.locations, id)
+	ref.segment.delete(ref.ordinal)
+	if ref.segment.live == 0 && !ref.segment.merging {
+		s.dropSegment(ref.segment)
+	}
+}
+
+func (s *Storage) dropSegment(seg *segment) {
+	for i, other := range s.segments.list {
+		if other == seg {
+			s.segments.list = append(s.segments.list[:i], s.segments.list[i+1:]...)
+			break
+		}
+	}
+	seg.close()
+	os.Remove(seg.path)
+}
+
+// lookupKey returns the docs holding key in memory or in any segment.
+func (s *Storage) lookupKey(key string) []DocID {
+	docs := s.index.getByKey(key)
+	if s.segments == nil {
+		return docs
+	}
+	for _, seg := range s.segments.list {
+		docs = unionEntries(docs, seg.getByKey(key))
+	}
+	return docs
+}
+
+// lookupQuery returns the docs holding every key of the query.
+func (s *Storage) lookupQuery(query string) []DocID {
+	if s.segments == nil {
+		return s.index.getByQuery(query)
+	}
+	keys := s.index.queryKeys(query)
+	if len(keys) == 0 {
+		return nil
+	}
+	docs := s.lookupKey(keys[0])
+	for _, key := range keys[1:] {
+		if len(docs) == 0 {
+			break
+		}
+		docs = intersectEntries(docs, s.lookupKey(key))
+	}
+	return docs
+}
+
+// lookupSubstring is getBySubstring across memory and segments.
+func (s *Storage) lookupSubstring(text string) ([]DocID, bool) {
+	docs, indexed := s.index.getBySubstring(text)
+	if !indexed || s.segments == nil {
+		return docs, indexed
+	}
+	for _, seg := range s.segments.list {
+		docs = unionEntries(docs, seg.getBySubstring(text))
+	}
+	return docs, true
+}
+
+// keysWithPrefix returns the sorted indexed words starting with prefix. A
+// prefix starting with "{" completes fields instead, shown as {name=value};
+// otherwise field and trigram keys are left out.
+func (s *Storage) keysWithPrefix(prefix string) []string {
+	lookup := prefix
+	if strings.HasPrefix(prefix, "{") {
+		lookup = fieldKeyPrefix + prefix[1:]
+	}
+	keys := s.index.keysWithPrefix(lookup)
+	if s.segments != nil {
+		for _, seg := range s.segments.list {
+			keys = append(keys, seg.keysWithPrefix(lookup)...)
+		}
+	}
+	seen := map[string]struct{}{}
+	words := []string{}
+	for _, key := range keys {
+		if lookup == "" && (strings.HasPrefix(key, fieldKeyPrefix) || strings.HasPrefix(key, gramKeyPrefix)) {
+			continue
+		}
+		if _, found := seen[key]; !found {
+			seen[key] = struct{}{}
+			words = append(words, displayKey(key))
+		}
+	}
+	sort.Strings(words)
+	return words
+}
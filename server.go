@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const maintenanceInterval = time.Second
+
+// listenAddrs are the addresses serve listens on. All but commands may be
+// empty to disable them.
+type listenAddrs struct {
+	commands  string
+	metrics   string
+	syslogUDP string
+	syslogTCP string
+}
+
+// serve accepts connections on addrs.commands until the process is
+// interrupted. Every connection gets its own command session on the shared
+// store. The store metrics are served over HTTP on addrs.metrics, and syslog
+// messages received on the syslog addresses are stored.
+func serve(store *Storage, addrs listenAddrs, compactInterval time.Duration, logOutput io.Writer) error {
+	listener, err := net.Listen("tcp", addrs.commands)
+	if err != nil {
+		return err
+	}
+	if addrs.syslogUDP != "" || addrs.syslogTCP != "" {
+		receiver := getNewSyslogReceiver(store, logOutput)
+		if err := receiver.listen(addrs.syslogUDP, addrs.syslogTCP); err != nil {
+			listener.Close()
+			return err
+		}
+		defer receiver.close()
+		if receiver.packets != nil {
+			fmt.Fprintf(logOutput, "receiving syslog on udp %s\n", receiver.packets.LocalAddr())
+		}
+		if receiver.listener != nil {
+			fmt.Fprintf(logOutput, "receiving syslog on tcp %s\n", receiver.listener.Addr())
+		}
+	}
+	if addrs.metrics != "" {
+		metricsListener, err := net.Listen("tcp", addrs.metrics)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metricsHandler(store))
+		server := &http.Server{Handler: mux}
+		defer server.Close()
+		go server.Serve(metricsListener)
+		fmt.Fprintf(logOutput, "serving metrics on http://%s/metrics\n", metricsListener.Addr())
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	go runMaintenance(ctx, store)
+	if store.segments != nil && compactInterval > 0 {
+		go runCompaction(ctx, store, compactInterval, logOutput)
+	}
+
+	fmt.Fprintf(logOutput, "listening on %s\n", listener.Addr())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go handleConnection(store, conn)
+	}
+}
+
+// runMaintenance periodically expires logs, flushes fresh logs to segments
+// and re-evaluates alert rules so that they resolve even when nothing is
+// being ingested.
+func runMaintenance(ctx context.Context, store *Storage) {
+	ticker := time.NewTicker(maintenanceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			store.mu.Lock()
+			store.expire()
+			store.maybeFlush(now)
+			store.alerts.evaluate(now)
+			store.mu.Unlock()
+		}
+	}
+}
+
+// runCompaction merges segments in the background whenever the merge
+// policy asks for it.
+func runCompaction(ctx context.Context, store *Storage, interval time.Duration, logOutput io.Writer) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				merged, err := compactInBackground(store)
+				if err != nil {
+					fmt.Fprintf(logOutput, "compaction failed: %v\n", err)
+				}
+				if !merged || err != nil || ctx.Err() != nil {
+					break
+				}
+			}
+		}
+	}
+}
+
+func handleConnection(store *Storage, conn io.ReadWriteCloser) {
+	defer conn.Close()
+	session := getNewSession(store, conn)
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	for scanner.Scan() {
+		command := strings.TrimRight(scanner.Text(), "\r")
+		if command == "" {
+			continue
+		}
+		store.mu.Lock()
+		session.execute(command)
+		store.mu.Unlock()
+		if command == "END" {
+			return
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+type Protocol string
+
+const (
+	ProtoText Protocol = "text"
+	ProtoJSON Protocol = "json"
+)
+
+// protocolVersion is reported in every JSON response and bumped whenever a
+// field changes meaning.
+const protocolVersion = 1
+
+type ResponseStatus string
+
+const (
+	StatusOK    ResponseStatus = "ok"
+	StatusError ResponseStatus = "error"
+	StatusEnd   ResponseStatus = "end"
+)
+
+type Result struct {
+	ID        LogID             `json:"id"`
+	Data      string            `json:"data,omitempty"`
+	CreatedAt *time.Time        `json:"created_at,omitempty"`
+	UpdatedAt *time.Time        `json:"updated_at,omitempty"`
+	Version   int               `json:"log_version,omitempty"`
+	Score     float64           `json:"score,omitempty"`
+	Highlight string            `json:"highlight,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+}
+
+func getResult(log Log, score float64) Result {
+	createdAt := log.CreatedAt
+	updatedAt := log.UpdatedAt
+	return Result{
+		ID:        log.ID,
+		Data:      log.Data,
+		CreatedAt: &createdAt,
+		UpdatedAt: &updatedAt,
+		Version:   log.Version,
+		Score:     score,
+		Fields:    log.Fields,
+	}
+}
+
+// Response is what a single command produced. In JSON mode it is written as
+// one object per line, in text mode only its text lines are written.
+type Response struct {
+	Version    int            `json:"version"`
+	Command    string         `json:"command"`
+	Status     ResponseStatus `json:"status"`
+	Results    []Result       `json:"results"`
+	Data       interface{}    `json:"data,omitempty"`
+	Error      *CommandError  `json:"error,omitempty"`
+	TookMicros int64          `json:"took_us"`
+	text       []string
+}
+
+// Session holds the per-client state of the command protocol.
+type Session struct {
+	store       *Storage
+	output      io.Writer
+	proto       Protocol
+	batch       *batch
+	highlighter Highlighter
+	importing   *snapshotImport
+}
+
+func getNewSession(store *Storage, output io.Writer) *Session {
+	return &Session{
+		store:       store,
+		output:      output,
+		proto:       ProtoText,
+		highlighter: markerHighlighter,
+	}
+}
+
+// execute runs command and writes its response. While an IMPORT is
+// streaming, lines are records and get no response of their own.
+func (s *Session) execute(command string) Response {
+	if s.importing != nil && command != importEnd {
+		s.importing.add(s.store, command)
+		return Response{Status: StatusOK}
+	}
+	response := s.run(command)
+	s.write(response)
+	return response
+}
+
+func (s *Session) run(command string) Response {
+	start := time.Now()
+	response, err := runCommandLine(s, command)
+	if err != nil {
+		var commandErr *CommandError
+		if !errors.As(err, &commandErr) {
+			commandErr = newCommandError(ErrInternal, "%v", err)
+		}
+		response = Response{Status: StatusError, Error: commandErr}
+	}
+	response.Version = protocolVersion
+	if fields := strings.Fields(command); len(fields) > 0 && response.Command == "" {
+		response.Command = fields[0]
+	}
+	if response.Results == nil {
+		response.Results = []Result{}
+	}
+	response.TookMicros = time.Since(start).Microseconds()
+	return response
+}
+
+func (s *Session) write(response Response) {
+	if s.proto == ProtoJSON {
+		data, err := json.Marshal(response)
+		if err != nil {
+			data, _ = json.Marshal(Response{
+				Version: protocolVersion,
+				Command: response.Command,
+				Status:  StatusError,
+				Results: []Result{},
+				Error:   newCommandError(ErrInternal, "%v", err),
+			})
+		}
+		s.output.Write(append(data, '\n'))
+		return
+	}
+	if response.Error != nil {
+		s.output.Write([]byte(formatError(response.Error)))
+		return
+	}
+	for _, line := range response.text {
+		s.output.Write([]byte(line + "\r\n"))
+	}
+}
+
+func (s *Session) processProto(arguments []string) (Response, error) {
+	if len(arguments) != 2 {
+		return Response{}, newCommandError(ErrBadArgument, "usage: PROTO text|json")
+	}
+	switch Protocol(strings.ToLower(arguments[1])) {
+	case ProtoText:
+		s.proto = ProtoText
+	case ProtoJSON:
+		s.proto = ProtoJSON
+	default:
+		return Response{}, newCommandError(ErrBadArgument, "unknown protocol %q", arguments[1])
+	}
+	data := map[string]interface{}{"proto": s.proto, "version": protocolVersion}
+	return Response{Status: StatusOK, Data: data, text: []string{"OK"}}, nil
+}
+
+func (s *Session) processSet(arguments []string) (Response, error) {
+	if len(arguments) < 2 {
+		return Response{}, newCommandError(ErrBadArgument, "usage: SET HIGHLIGHT ansi|markers [pre] [post]")
+	}
+	switch strings.ToUpper(arguments[1]) {
+	case "HIGHLIGHT":
+		highlighter, err := getHighlighter(arguments[2:])
+		if err != nil {
+			return Response{}, newCommandError(ErrBadArgument, "%v", err)
+		}
+		s.highlighter = highlighter
+	default:
+		return Response{}, newCommandError(ErrBadArgument, "unknown setting %q", arguments[1])
+	}
+	return Response{Status: StatusOK, text: []string{"OK"}}, nil
+}
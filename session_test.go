@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSession_execute(t *testing.T) {
+	type want struct {
+		Command string
+		Status  ResponseStatus
+		IDs     []LogID
+		Error   *CommandError
+	}
+	tests := []struct {
+		name     string
+		commands []string
+		want     []want
+	}{
+		{
+			"search results",
+			[]string{"ADD 1 hello world", "ADD 2 hello hello", "SEARCH hello 5"},
+			[]want{
+				{"ADD", StatusOK, []LogID{"1"}, nil},
+				{"ADD", StatusOK, []LogID{"2"}, nil},
+				{"SEARCH", StatusOK, []LogID{"2", "1"}, nil},
+			},
+		},
+		{
+			"empty result is not an error",
+			[]string{"SEARCH hello 5"},
+			[]want{{"SEARCH", StatusOK, []LogID{}, nil}},
+		},
+		{
+			"errors are reported in the response",
+			[]string{"SEARCH hello", "NOPE"},
+			[]want{
+				{"SEARCH", StatusError, []LogID{}, &CommandError{ErrBadArgument, "usage: SEARCH [word | {name=value} | *substring* | /regexp/] [limit] [HISTORY] [PAGE | AFTER cursor] [HIGHLIGHT] [SNIPPET width]"}},
+				{"NOPE", StatusError, []LogID{}, &CommandError{ErrUnknownCommand, "unknown command \"NOPE\""}},
+			},
+		},
+		{
+			"end",
+			[]string{"END"},
+			[]want{{"END", StatusEnd, []LogID{}, nil}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := &bytes.Buffer{}
+			session := getNewSession(getNewStore(3), output)
+			session.execute("PROTO json")
+			for _, command := range tt.commands {
+				session.execute(command)
+			}
+
+			lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+			if len(lines) != len(tt.want)+1 {
+				t.Fatalf("execute() wrote %d lines, want %d", len(lines), len(tt.want)+1)
+			}
+			for i, line := range lines[1:] {
+				var response Response
+				if err := json.Unmarshal([]byte(line), &response); err != nil {
+					t.Fatalf("execute() wrote invalid json %q: %v", line, err)
+				}
+				ids := []LogID{}
+				for _, result := range response.Results {
+					ids = append(ids, result.ID)
+				}
+				got := want{response.Command, response.Status, ids, response.Error}
+				if response.Version != protocolVersion {
+					t.Errorf("execute() version = %d, want %d", response.Version, protocolVersion)
+				}
+				if !reflect.DeepEqual(got, tt.want[i]) {
+					t.Errorf("execute() response %d = %+v, want %+v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSession_processProto(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		want     string
+	}{
+		{"text is the default", []string{"SEARCH hello 1"}, "NONE\r\n"},
+		{"switch back to text", []string{"PROTO json", "PROTO text", "SEARCH hello 1"}, "OK\r\nNONE\r\n"},
+		{"unknown protocol", []string{"PROTO xml"}, "ERR BAD_ARGUMENT unknown protocol \"xml\"\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := &bytes.Buffer{}
+			session := getNewSession(getNewStore(3), output)
+			for _, command := range tt.commands {
+				session.execute(command)
+			}
+			got := output.String()
+			if i := strings.LastIndex(got, "}\n"); i >= 0 {
+				got = got[i+2:]
+			}
+			if got != tt.want {
+				t.Errorf("execute() output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzSession_execute(f *testing.F) {
+	for _, seed := range []string{
+		"ADD 1 disk full", "APPEND cpu hot", "SEARCH disk 5 PAGE", "SEARCH /(/ 1", "SET HIGHLIGHT ansi",
+		"HISTORY 1", "RULE ADD r disk 1m 2", "BEGIN", "COMMIT", "PATTERNS 3", "STATS", "INFO 1",
+		"TERM disk", "CHECK REPAIR", "PROTO json", "FLUSH", ".",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, command string) {
+		output := &bytes.Buffer{}
+		session := getNewSession(getNewStore(10), output)
+		session.execute("ADD 1 disk full")
+		output.Reset()
+		response := session.execute(command)
+		if response.Error != nil && response.Error.Code == ErrInternal {
+			t.Fatalf("execute(%q) failed internally: %v", command, response.Error)
+		}
+		if got := output.String(); got != "" && !strings.HasSuffix(got, "\n") {
+			t.Errorf("execute(%q) output %q isn't line terminated", command, got)
+		}
+		if issues := session.store.check(); len(issues) > 0 {
+			t.Errorf("execute(%q) left the store inconsistent: %q", command, issues)
+		}
+	})
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type snapshotFormat string
+
+const (
+	formatJSONL snapshotFormat = "jsonl"
+	formatCSV   snapshotFormat = "csv"
+)
+
+// importEnd ends the records of an IMPORT sent over the session.
+const importEnd = "."
+
+var csvHeader = []string{"id", "created_at", "updated_at", "version", "data", "fields"}
+
+// snapshotRecord is how a log is written to a JSON Lines snapshot.
+type snapshotRecord struct {
+	ID        LogID             `json:"id"`
+	Data      string            `json:"data"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	Version   int               `json:"version"`
+	Fields    map[string]string `json:"fields,omitempty"`
+}
+
+func getSnapshotFormat(name string) (snapshotFormat, error) {
+	switch format := snapshotFormat(strings.ToLower(name)); format {
+	case formatJSONL, formatCSV:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown format %q, expected jsonl or csv", name)
+}
+
+// snapshotHeader is the line written before the records, if any.
+func snapshotHeader(format snapshotFormat) string {
+	if format == formatCSV {
+		return strings.Join(csvHeader, ",")
+	}
+	return ""
+}
+
+// encodeSnapshotRecord returns log as a single snapshot line. Log data never
+// holds a newline, so every record fits on one.
+func encodeSnapshotRecord(format snapshotFormat, log Log) (string, error) {
+	if format == formatJSONL {
+		data, err := json.Marshal(snapshotRecord{log.ID, log.Data, log.CreatedAt, log.UpdatedAt, log.Version, log.Fields})
+		return string(data), err
+	}
+	buffer := &bytes.Buffer{}
+	writer := csv.NewWriter(buffer)
+	writer.Write([]string{
+		string(log.ID),
+		log.CreatedAt.Format(time.RFC3339Nano),
+		log.UpdatedAt.Format(time.RFC3339Nano),
+		strconv.Itoa(log.Version),
+		log.Data,
+		encodeFields(log.Fields),
+	})
+	writer.Flush()
+	return strings.TrimRight(buffer.String(), "\n"), writer.Error()
+}
+
+// decodeSnapshotRecord parses one snapshot line. The second result is false
+// for lines that hold no record, like the CSV header.
+func decodeSnapshotRecord(format snapshotFormat, line string) (Log, bool, error) {
+	if strings.TrimSpace(line) == "" {
+		return Log{}, false, nil
+	}
+	var record snapshotRecord
+	if format == formatJSONL {
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return Log{}, false, err
+		}
+	} else {
+		fields, err := csv.NewReader(strings.NewReader(line)).Read()
+		if err != nil {
+			return Log{}, false, err
+		}
+		// Snapshots written before logs had fields have no fields column.
+		if len(fields) != len(csvHeader) && len(fields) != len(csvHeader)-1 {
+			return Log{}, false, fmt.Errorf("expected %d fields, got %d", len(csvHeader), len(fields))
+		}
+		if strings.Join(fields, ",") == strings.Join(csvHeader[:len(fields)], ",") {
+			return Log{}, false, nil
+		}
+		record.ID = LogID(fields[0])
+		if record.CreatedAt, err = time.Parse(time.RFC3339Nano, fields[1]); err != nil {
+			return Log{}, false, err
+		}
+		if record.UpdatedAt, err = time.Parse(time.RFC3339Nano, fields[2]); err != nil {
+			return Log{}, false, err
+		}
+		if record.Version, err = strconv.Atoi(fields[3]); err != nil {
+			return Log{}, false, err
+		}
+		record.Data = fields[4]
+		if len(fields) == len(csvHeader) {
+			if record.Fields, err = decodeFields(fields[5]); err != nil {
+				return Log{}, false, err
+			}
+		}
+	}
+	id, err := parseLogID(string(record.ID))
+	if err != nil {
+		return Log{}, false, err
+	}
+	// A lone \r is kept, as ADD keeps it; JSON escapes it and CSV quotes it.
+	if strings.Contains(record.Data, "\n") {
+		return Log{}, false, fmt.Errorf("data of %q spans several lines", id)
+	}
+	if record.Version < 1 {
+		record.Version = 1
+	}
+	if record.UpdatedAt.IsZero() {
+		record.UpdatedAt = record.CreatedAt
+	}
+	return Log{ID: id, Data: record.Data, CreatedAt: record.CreatedAt, UpdatedAt: record.UpdatedAt, Version: record.Version, Fields: record.Fields}, true, nil
+}
+
+// exportLogs writes the store as a snapshot, one record per line in
+// eviction order, and returns how many logs were written.
+func exportLogs(store *Storage, format snapshotFormat, writeLine func(line string) error) (int, error) {
+	if header := snapshotHeader(format); header != "" {
+		if err := writeLine(header); err != nil {
+			return 0, err
+		}
+	}
+	count := 0
+	err := store.eachLog(func(log Log) error {
+		line, err := encodeSnapshotRecord(format, log)
+		if err != nil {
+			return err
+		}
+		count++
+		return writeLine(line)
+	})
+	return count, err
+}
+
+// snapshotImport tracks an IMPORT whose records arrive over the session.
+// Records are stored as they arrive; after the first bad one the rest are
+// skipped and the import fails when it ends.
+type snapshotImport struct {
+	format snapshotFormat
+	lines  int
+	count  int
+	failed *CommandError
+}
+
+func (i *snapshotImport) add(store *Storage, line string) {
+	i.lines++
+	if i.failed != nil {
+		return
+	}
+	log, ok, err := decodeSnapshotRecord(i.format, line)
+	if err != nil {
+		i.failed = newCommandError(ErrBadArgument, "import stopped at line %d: %v", i.lines, err)
+		return
+	}
+	if ok {
+		store.importLog(log)
+		i.count++
+	}
+}
+
+func (i *snapshotImport) finish() (Response, error) {
+	if i.failed != nil {
+		return Response{}, newCommandError(i.failed.Code, "%s, %d logs imported", i.failed.Message, i.count)
+	}
+	return countResponse(i.count), nil
+}
+
+func countResponse(count int) Response {
+	return Response{
+		Status: StatusOK,
+		Data:   map[string]int{"count": count},
+		text:   []string{fmt.Sprintf("OK %d", count)},
+	}
+}
+
+// processExport streams the records as lines ahead of the "OK [count]"
+// reply. In JSON mode, where every command gets a single object, they are
+// the "records" of the reply instead.
+func (s *Session) processExport(arguments []string) (Response, error) {
+	if len(arguments) != 2 {
+		return Response{}, newCommandError(ErrBadArgument, "usage: EXPORT jsonl|csv")
+	}
+	format, err := getSnapshotFormat(arguments[1])
+	if err != nil {
+		return Response{}, newCommandError(ErrBadArgument, "%v", err)
+	}
+	records := []string{}
+	writeLine := func(line string) error {
+		if s.proto == ProtoJSON {
+			records = append(records, line)
+			return nil
+		}
+		_, err := io.WriteString(s.output, line+"\r\n")
+		return err
+	}
+	count, err := exportLogs(s.store, format, writeLine)
+	if err != nil {
+		return Response{}, err
+	}
+	response := countResponse(count)
+	if s.proto == ProtoJSON {
+		response.Data = map[string]interface{}{"count": count, "records": records}
+	}
+	return response, nil
+}
+
+// processImport replies OK and takes the following lines as records up to
+// a "." line.
+func (s *Session) processImport(arguments []string) (Response, error) {
+	if s.batch != nil {
+		return Response{}, newCommandError(ErrBadState, "IMPORT is not allowed in a batch")
+	}
+	if len(arguments) != 2 {
+		return Response{}, newCommandError(ErrBadArgument, "usage: IMPORT jsonl|csv")
+	}
+	format, err := getSnapshotFormat(arguments[1])
+	if err != nil {
+		return Response{}, newCommandError(ErrBadArgument, "%v", err)
+	}
+	s.importing = &snapshotImport{format: format}
+	return Response{Status: StatusOK, text: []string{"OK"}}, nil
+}
+
+// processImportEnd finishes an IMPORT sent over the session.
+func (s *Session) processImportEnd() (Response, error) {
+	if s.importing == nil {
+		return Response{}, newCommandError(ErrBadState, "no import in progress")
+	}
+	importing := s.importing
+	s.importing = nil
+	response, err := importing.finish()
+	response.Command = "IMPORT"
+	return response, err
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_encodeSnapshotRecord(t *testing.T) {
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	log := Log{ID: "7", Data: `GET /a?x=1,2 "quoted"`, CreatedAt: createdAt, UpdatedAt: createdAt.Add(time.Second), Version: 2, Fields: map[string]string{"host": "web 1", "app": "nginx"}}
+	tests := []struct {
+		name   string
+		format snapshotFormat
+		want   string
+	}{
+		{
+			"jsonl",
+			formatJSONL,
+			`{"id":"7","data":"GET /a?x=1,2 \"quoted\"","created_at":"2024-05-01T12:00:00Z","updated_at":"2024-05-01T12:00:01Z","version":2,"fields":{"app":"nginx","host":"web 1"}}`,
+		},
+		{
+			"csv",
+			formatCSV,
+			`7,2024-05-01T12:00:00Z,2024-05-01T12:00:01Z,2,"GET /a?x=1,2 ""quoted""",app=nginx&host=web+1`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := encodeSnapshotRecord(tt.format, log)
+			if err != nil || got != tt.want {
+				t.Fatalf("encodeSnapshotRecord() = %q, %v, want %q", got, err, tt.want)
+			}
+			decoded, ok, err := decodeSnapshotRecord(tt.format, got)
+			if err != nil || !ok || !reflect.DeepEqual(decoded, log) {
+				t.Errorf("decodeSnapshotRecord() = %+v, %v, %v, want %+v", decoded, ok, err, log)
+			}
+		})
+	}
+}
+
+func Test_decodeSnapshotRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  snapshotFormat
+		line    string
+		wantOK  bool
+		wantErr bool
+	}{
+		{"csv header", formatCSV, "id,created_at,updated_at,version,data,fields", false, false},
+		{"csv header without fields", formatCSV, "id,created_at,updated_at,version,data", false, false},
+		{"csv without fields", formatCSV, "7,2024-05-01T12:00:00Z,2024-05-01T12:00:00Z,1,hello", true, false},
+		{"bad fields", formatCSV, "7,2024-05-01T12:00:00Z,2024-05-01T12:00:00Z,1,hello,%zz", false, true},
+		{"blank line", formatJSONL, "", false, false},
+		{"missing fields", formatCSV, "7,2024-05-01T12:00:00Z", false, true},
+		{"bad time", formatCSV, "7,yesterday,yesterday,1,hello", false, true},
+		{"bad json", formatJSONL, "{", false, true},
+		{"empty id", formatJSONL, `{"id":"","data":"hello"}`, false, true},
+		{"missing version", formatJSONL, `{"id":"a","data":"hello"}`, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ok, err := decodeSnapshotRecord(tt.format, tt.line)
+			if ok != tt.wantOK || (err != nil) != tt.wantErr {
+				t.Errorf("decodeSnapshotRecord() = %v, %v, want %v, error %v", ok, err, tt.wantOK, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSession_exportImport(t *testing.T) {
+	for _, format := range []string{"jsonl", "csv"} {
+		t.Run(format, func(t *testing.T) {
+			source := getNewStore(10)
+			source.upsertLog("b", "first line")
+			source.upsertLog("a", "second, line\rwith a carriage return")
+			source.upsertLog("b", "first line updated")
+			output := &bytes.Buffer{}
+			getNewSession(source, output).execute("EXPORT " + format)
+			lines := strings.Split(strings.TrimSuffix(output.String(), "\r\n"), "\r\n")
+			if lines[len(lines)-1] != "OK 2" {
+				t.Fatalf("EXPORT output = %q", output.String())
+			}
+
+			target := getNewStore(2)
+			target.upsertLog("c", "already here")
+			output.Reset()
+			session := getNewSession(target, output)
+			session.execute("IMPORT " + format)
+			for _, line := range lines[:len(lines)-1] {
+				session.execute(line)
+			}
+			session.execute(".")
+			if output.String() != "OK\r\nOK 2\r\n" {
+				t.Fatalf("IMPORT output = %q", output.String())
+			}
+			for _, id := range []LogID{"b", "a"} {
+				log, _ := source.getLogById(id)
+				want, _ := encodeSnapshotRecord(formatJSONL, log)
+				log, err := target.getLogById(id)
+				got, _ := encodeSnapshotRecord(formatJSONL, log)
+				if err != nil || got != want {
+					t.Errorf("imported log = %s, %v, want %s", got, err, want)
+				}
+			}
+			if _, err := target.getLogById("c"); err == nil {
+				t.Errorf("IMPORT should evict the oldest log beyond capacity")
+			}
+			if got := target.getLogsByWord("updated", 5); len(got) != 1 || got[0].ID != "b" {
+				t.Errorf("imported logs are not indexed: %v", got)
+			}
+
+			target.upsertLog("d", "newest")
+			if _, err := target.getLogById("b"); err == nil {
+				t.Errorf("imported logs should be evicted in snapshot order")
+			}
+		})
+	}
+}
+
+func TestSession_exportJSON(t *testing.T) {
+	store := getNewStore(10)
+	store.upsertLog("1", "hello")
+	output := &bytes.Buffer{}
+	session := getNewSession(store, output)
+	session.execute("PROTO json")
+	output.Reset()
+	session.execute("EXPORT csv")
+
+	var response struct {
+		Status string
+		Data   struct {
+			Count   int
+			Records []string
+		}
+	}
+	if err := json.Unmarshal(output.Bytes(), &response); err != nil {
+		t.Fatalf("EXPORT output %q isn't a single JSON object: %v", output.String(), err)
+	}
+	log, _ := store.getLogById("1")
+	record, _ := encodeSnapshotRecord(formatCSV, log)
+	want := []string{snapshotHeader(formatCSV), record}
+	if response.Status != "ok" || response.Data.Count != 1 || !reflect.DeepEqual(response.Data.Records, want) {
+		t.Errorf("EXPORT response = %+v, want records %q", response, want)
+	}
+}
+
+func TestSession_importStream(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		want     string
+	}{
+		{
+			"records until the end line",
+			[]string{"IMPORT csv", "id,created_at,updated_at,version,data", "1,2024-05-01T12:00:00Z,2024-05-01T12:00:00Z,1,hello", ".", "SEARCH hello 5"},
+			"OK\r\nOK 1\r\n1\r\n",
+		},
+		{
+			"a bad record stops the import",
+			[]string{"IMPORT jsonl", `{"id":"1","data":"hello"}`, "nope", `{"id":"2","data":"hello"}`, ".", "SEARCH hello 5"},
+			"OK\r\nERR BAD_ARGUMENT import stopped at line 2: invalid character 'o' in literal null (expecting 'u'), 1 logs imported\r\n1\r\n",
+		},
+		{
+			"stream export",
+			[]string{"ADD 1 hello", "EXPORT jsonl"},
+			`{"id":"1","data":"hello",`,
+		},
+		{
+			"no server paths",
+			[]string{"EXPORT csv /tmp/snapshot.csv", "IMPORT jsonl /etc/passwd"},
+			"ERR BAD_ARGUMENT usage: EXPORT jsonl|csv\r\nERR BAD_ARGUMENT usage: IMPORT jsonl|csv\r\n",
+		},
+		{
+			"unknown format",
+			[]string{"IMPORT xml"},
+			"ERR BAD_ARGUMENT unknown format \"xml\", expected jsonl or csv\r\n",
+		},
+		{
+			"end without import",
+			[]string{"."},
+			"ERR BAD_STATE no import in progress\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := &bytes.Buffer{}
+			session := getNewSession(getNewStore(10), output)
+			for _, command := range tt.commands {
+				session.execute(command)
+			}
+			if got := output.String(); !strings.HasPrefix(got, tt.want) {
+				t.Errorf("execute() output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func startTestServer(t *testing.T, store *Storage) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleConnection(store, conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func Test_runCLI_snapshot(t *testing.T) {
+	source := getNewStore(10)
+	source.upsertLog("1", "disk full")
+	source.upsertLog("2", "disk ok")
+	target := getNewStore(10)
+	path := filepath.Join(t.TempDir(), "snapshot.csv")
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	args := []string{"export", "--addr", startTestServer(t, source), "--format", "csv", "--output", path}
+	if code := runCLI(args, nil, stdout, stderr); code != exitOK {
+		t.Fatalf("export exited with %d: %s", code, stderr)
+	}
+	snapshot, _ := os.ReadFile(path)
+	if lines := strings.Split(strings.TrimSpace(string(snapshot)), "\n"); len(lines) != 3 {
+		t.Fatalf("export wrote %q", snapshot)
+	}
+
+	args = []string{"import", "--addr", startTestServer(t, target), "--format", "csv", "--input", path}
+	if code := runCLI(args, nil, stdout, stderr); code != exitOK {
+		t.Fatalf("import exited with %d: %s", code, stderr)
+	}
+	if stdout.String() != "OK 2\n" {
+		t.Errorf("import output = %q", stdout.String())
+	}
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	if got := target.getLogsByWord("disk", 5); len(got) != 2 {
+		t.Errorf("imported %d logs, want 2", len(got))
+	}
+}
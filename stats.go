@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+const defaultTopTerms = 5
+
+// Sizes used by the memory estimate.
+const (
+	stringHeaderSize = int64(unsafe.Sizeof(""))
+	sliceHeaderSize  = int64(unsafe.Sizeof([]DocID{}))
+	docIDSize        = int64(unsafe.Sizeof(DocID(0)))
+	logSize          = int64(unsafe.Sizeof(Log{}))
+)
+
+// TermStats is the posting list size of one indexed word.
+type TermStats struct {
+	Term     string `json:"term"`
+	Postings int    `json:"postings"`
+}
+
+// StoreStats describes the store for STATS. Logs counts the stored logs,
+// Buffer the eviction queue; the two only differ when the store is
+// inconsistent.
+type StoreStats struct {
+	Logs        int         `json:"logs"`
+	Capacity    int         `json:"capacity"`
+	Buffer      int         `json:"buffer"`
+	Flushed     int         `json:"flushed"`
+	Terms       int         `json:"terms"`
+	Postings    int         `json:"postings"`
+	TopTerms    []TermStats `json:"top_terms"`
+	MemoryBytes int64       `json:"memory_bytes"`
+	Evictions   uint64      `json:"evictions"`
+	Expirations uint64      `json:"expirations"`
+}
+
+// LogInfo is what the index holds about one log, for INFO.
+type LogInfo struct {
+	ID      LogID    `json:"id"`
+	DocID   DocID    `json:"doc_id"`
+	Version int      `json:"log_version"`
+	Segment string   `json:"segment,omitempty"`
+	Terms   []string `json:"terms"`
+}
+
+// topKeys returns the n keys with the longest posting lists, longest first
+// and by key among equals.
+func (i *InvertedIndex) topKeys(n int) []TermStats {
+	top := []TermStats{}
+	for key, docs := range i.keyToEntries {
+		if len(docs) > 0 {
+			top = append(top, TermStats{Term: displayKey(key), Postings: len(docs)})
+		}
+	}
+	sort.Slice(top, func(a, b int) bool {
+		if top[a].Postings != top[b].Postings {
+			return top[a].Postings > top[b].Postings
+		}
+		return top[a].Term < top[b].Term
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// memory roughly estimates the bytes held by both maps of the index and its
+// trigram index, counting keys once.
+func (i *InvertedIndex) memory() int64 {
+	size := int64(0)
+	for key, docs := range i.keyToEntries {
+		size += stringHeaderSize + int64(len(key)) + sliceHeaderSize + int64(cap(docs))*docIDSize
+	}
+	for _, keys := range i.entryToKeys {
+		size += docIDSize + sliceHeaderSize + int64(cap(keys))*stringHeaderSize
+	}
+	if i.grams != nil {
+		size += i.grams.memory()
+	}
+	return size
+}
+
+// getStats returns the store statistics with the top largest posting lists
+// of the in-memory index.
+func (s *Storage) getStats(top int) StoreStats {
+	s.expire()
+	keys, entries := s.index.stats()
+	stats := StoreStats{
+		Logs:        len(s.logsStorage),
+		Capacity:    s.capacity,
+		Buffer:      s.buffer.Len(),
+		Terms:       keys,
+		Postings:    entries,
+		TopTerms:    s.index.topKeys(top),
+		MemoryBytes: s.index.memory(),
+		Evictions:   s.metrics.evictions,
+		Expirations: s.metrics.expirations,
+	}
+	for id, log := range s.logsStorage {
+		stats.MemoryBytes += logSize + int64(len(id)) + int64(len(log.Data))
+	}
+	if s.segments != nil {
+		stats.Flushed = len(s.segments.locations)
+		stats.Logs += stats.Flushed
+	}
+	return stats
+}
+
+// getLogInfo returns the indexed terms of a log: those in entryToKeys for a
+// fresh log, those whose segment posting lists hold it for a flushed one.
+func (s *Storage) getLogInfo(id LogID) (LogInfo, error) {
+	log, err := s.getLogById(id)
+	if err != nil {
+		return LogInfo{}, err
+	}
+	info := LogInfo{ID: log.ID, DocID: log.DocID, Version: log.Version}
+	if _, found := s.logsStorage[id]; found {
+		info.Terms = []string{}
+		for _, key := range s.index.entryToKeys[log.DocID] {
+			info.Terms = append(info.Terms, displayKey(key))
+		}
+		return info, nil
+	}
+	ref := s.segments.locations[id]
+	info.Segment = filepath.Base(ref.segment.path)
+	info.Terms = ref.segment.keysOf(ref.ordinal)
+	return info, nil
+}
+
+// keysOf returns the words whose posting lists hold the doc at ordinal. It
+// scans the whole term table.
+func (s *segment) keysOf(ordinal uint32) []string {
+	keys := []string{}
+	for term := 0; term < s.terms; term++ {
+		key := s.key(term)
+		if strings.HasPrefix(key, gramKeyPrefix) {
+			continue
+		}
+		ordinals := s.ordinals(term)
+		i := sort.Search(len(ordinals), func(i int) bool { return ordinals[i] >= ordinal })
+		if i < len(ordinals) && ordinals[i] == ordinal {
+			keys = append(keys, displayKey(key))
+		}
+	}
+	return keys
+}
+
+// processStats reports the store statistics as "name value" lines, followed
+// by a "top term postings" line per largest posting list.
+func processStats(store *Storage, arguments []string) (Response, error) {
+	top := defaultTopTerms
+	if len(arguments) > 2 {
+		return Response{}, newCommandError(ErrBadArgument, "usage: STATS [n]")
+	}
+	if len(arguments) == 2 {
+		n, err := strconv.Atoi(arguments[1])
+		if err != nil || n < 0 {
+			return Response{}, newCommandError(ErrBadArgument, "invalid count %q", arguments[1])
+		}
+		top = n
+	}
+	stats := store.getStats(top)
+	lines := []string{
+		fmt.Sprintf("logs %d", stats.Logs),
+		fmt.Sprintf("capacity %d", stats.Capacity),
+		fmt.Sprintf("buffer %d", stats.Buffer),
+		fmt.Sprintf("flushed %d", stats.Flushed),
+		fmt.Sprintf("terms %d", stats.Terms),
+		fmt.Sprintf("postings %d", stats.Postings),
+		fmt.Sprintf("memory %d", stats.MemoryBytes),
+		fmt.Sprintf("evictions %d", stats.Evictions),
+		fmt.Sprintf("expirations %d", stats.Expirations),
+	}
+	for _, term := range stats.TopTerms {
+		lines = append(lines, fmt.Sprintf("top %s %d", term.Term, term.Postings))
+	}
+	return Response{Status: StatusOK, Data: stats, text: lines}, nil
+}
+
+// processInfo replies with "[doc id] [version] [terms...]" for a log.
+func processInfo(store *Storage, arguments []string) (Response, error) {
+	if len(arguments) != 2 {
+		return Response{}, newCommandError(ErrBadArgument, "usage: INFO [id]")
+	}
+	info, err := store.getLogInfo(LogID(arguments[1]))
+	if err != nil {
+		return Response{}, newCommandError(ErrNotFound, "log %q not found", arguments[1])
+	}
+	fields := append([]string{fmt.Sprint(info.DocID), strconv.Itoa(info.Version)}, info.Terms...)
+	return Response{Status: StatusOK, Data: info, text: []string{strings.Join(fields, " ")}}, nil
+}
+
+// processTerm replies with the posting list size of an index key, or of a
+// "{name=value}" field, across memory and segments.
+func processTerm(store *Storage, arguments []string) (Response, error) {
+	if len(arguments) != 2 {
+		return Response{}, newCommandError(ErrBadArgument, "usage: TERM [word]")
+	}
+	key := arguments[1]
+	if fieldKey, ok := parseFieldQuery(key); ok {
+		key = fieldKey
+	}
+	return countResponse(len(store.lookupKey(key))), nil
+}
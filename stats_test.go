@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestStorage_getStats(t *testing.T) {
+	store := getNewStore(2)
+	store.upsertLog("1", "disk full")
+	store.upsertLog("2", "disk error")
+	store.upsertLog("3", "disk error again")
+	got := store.getStats(2)
+	if got.MemoryBytes <= 0 {
+		t.Errorf("MemoryBytes = %d, want more than 0", got.MemoryBytes)
+	}
+	got.MemoryBytes = 0
+	want := StoreStats{
+		Logs:      2,
+		Capacity:  2,
+		Buffer:    2,
+		Terms:     3,
+		Postings:  5,
+		TopTerms:  []TermStats{{"disk", 2}, {"error", 2}},
+		Evictions: 1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getStats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestStorage_getLogInfo(t *testing.T) {
+	s := segmentedStore(t, 10, []string{"1"})
+	s.upsertLog("2", "fresh log 2")
+	tests := []struct {
+		name    string
+		id      LogID
+		want    LogInfo
+		wantErr bool
+	}{
+		{"fresh", "2", LogInfo{ID: "2", DocID: 1, Version: 1, Terms: []string{"fresh", "log", "2"}}, false},
+		{"flushed", "1", LogInfo{ID: "1", DocID: 0, Version: 1, Segment: "000001.seg", Terms: []string{"1", "log"}}, false},
+		{"missing", "3", LogInfo{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.getLogInfo(tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getLogInfo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getLogInfo() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSession_introspection(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		want     string
+	}{
+		{"info", []string{"ADD 1 disk full", "ADD 1 disk error", "INFO 1"}, "0 2 disk error\r\n"},
+		{"info missing", []string{"INFO 1"}, "ERR NOT_FOUND log \"1\" not found\r\n"},
+		{"info usage", []string{"INFO"}, "ERR BAD_ARGUMENT usage: INFO [id]\r\n"},
+		{"term", []string{"ADD 1 disk full", "ADD 2 disk error", "TERM disk", "TERM full", "TERM cpu"}, "OK 2\r\nOK 1\r\nOK 0\r\n"},
+		{"term usage", []string{"TERM a b"}, "ERR BAD_ARGUMENT usage: TERM [word]\r\n"},
+		{"stats count", []string{"STATS x"}, "ERR BAD_ARGUMENT invalid count \"x\"\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := &bytes.Buffer{}
+			session := getNewSession(getNewStore(10), output)
+			for _, command := range tt.commands {
+				session.execute(command)
+			}
+			if got := output.String(); got != tt.want {
+				t.Errorf("execute() output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSession_processStats(t *testing.T) {
+	session := getNewSession(getNewStore(10), &bytes.Buffer{})
+	session.execute("ADD 1 disk full")
+	response := session.execute("STATS 1")
+	stats, ok := response.Data.(StoreStats)
+	if !ok || response.Status != StatusOK {
+		t.Fatalf("STATS = %+v", response)
+	}
+	want := []string{"logs 1", "capacity 10", "buffer 1", "flushed 0", "terms 2", "postings 2"}
+	if !reflect.DeepEqual(response.text[:len(want)], want) {
+		t.Errorf("STATS text = %q, want it to start with %q", response.text, want)
+	}
+	if last := response.text[len(response.text)-1]; last != "top disk 1" || len(stats.TopTerms) != 1 {
+		t.Errorf("STATS top terms = %q, %v", last, stats.TopTerms)
+	}
+}
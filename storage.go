@@ -3,6 +3,9 @@ package main
 import (
 	"fmt"
 	"sort"
+	"strconv"
+	"sync"
+	"time"
 )
 
 type LogsStorage map[LogID]Log
@@ -18,63 +21,300 @@ type Storage struct {
 	index       InvertedIndex
 	buffer      Buffer
 	capacity    int
+	ttl         time.Duration
+	mu          sync.Mutex
+	hooks       storageHooks
+	alerts      *AlertManager
+	nextID      uint64
+	docs        map[DocID]LogID
+	freeDocs    []DocID
+	nextDoc     DocID
+	history     versionHistory
+	analyzer    Analyzer
+	patterns    *patternMiner
+	segments    *segmentSet
+	metrics     storeMetrics
+	// now is the store clock, time.Now unless a test replaces it.
+	now     func() time.Time
+	nextSeq uint64
+	// expiry orders the stored logs by CreatedAt for the ttl.
+	expiry *expiryQueue
+	// timestamps, when set, reads the time of new writes from their data.
+	timestamps *timeExtractor
+}
+
+type storageHooks struct {
+	onIngest []func(log Log)
+	// onRemove runs for a log version that leaves the store, because it was
+	// evicted, expired or replaced by an update.
+	onRemove []func(log Log)
+}
+
+func (s *Storage) addIngestHook(hook func(log Log)) {
+	s.hooks.onIngest = append(s.hooks.onIngest, hook)
+}
+
+func (s *Storage) addRemoveHook(hook func(log Log)) {
+	s.hooks.onRemove = append(s.hooks.onRemove, hook)
 }
 
 func getNewStore(s int) *Storage {
-	return &Storage{
+	store := &Storage{
 		logsStorage: LogsStorage{},
 		index:       getNewIndex(),
 		buffer:      getNewBuffer(),
 		capacity:    s,
+		docs:        map[DocID]LogID{},
+		history:     getNewVersionHistory(defaultHistoryLimit),
+		analyzer:    analyzeWhitespace,
+		metrics:     getNewStoreMetrics(),
+		now:         time.Now,
+		expiry:      getNewExpiryQueue(),
 	}
+	store.alerts = getNewAlertManager()
+	store.alerts.now = func() time.Time { return store.now() }
+	store.alerts.tokenize = store.index.words
+	store.addIngestHook(store.alerts.observe)
+	store.patterns = getNewPatternMiner()
+	store.addIngestHook(store.patterns.add)
+	store.addRemoveHook(store.patterns.remove)
+	return store
+}
+
+func (s *Storage) setAnalyzer(analyzer Analyzer) {
+	s.analyzer = analyzer
+	s.index.tokenize = analyzer.terms
+	s.history.index.tokenize = analyzer.terms
+}
+
+// enableTrigrams adds trigram indexes to the current and history indexes so
+// substring searches don't scan every log.
+func (s *Storage) enableTrigrams() {
+	s.index.enableGrams()
+	s.history.index.enableGrams()
 }
 
 func (s *Storage) upsertLog(id LogID, data string) {
-	existingLog, err := s.getLogById(id)
+	s.putLog(Log{ID: id, Data: data})
+	s.cleanup()
+}
+
+// upsertLogs stores a whole batch and applies capacity eviction once, after
+// every log of the batch has been indexed.
+func (s *Storage) upsertLogs(logs []Log) {
+	for _, log := range logs {
+		s.putLog(log)
+	}
+	s.cleanup()
+}
+
+// putLog stores the id, data and fields of log. Its CreatedAt, when set, is
+// the time of the write: the creation time of a new log or the update time
+// of an existing one. Otherwise the time is read from the data when it
+// starts with a known timestamp, and taken from the store clock when it
+// doesn't.
+func (s *Storage) putLog(log Log) {
+	s.observeID(log.ID)
+	s.metrics.ingested++
+	at := log.CreatedAt
+	if at.IsZero() {
+		at = s.now()
+		if s.timestamps != nil {
+			if t, ok := s.timestamps.extract(log.Data, at); ok {
+				at = t
+			}
+		}
+	}
+	existingLog, err := s.getLogById(log.ID)
 	if err != nil {
-		newLog := getNewLog(id, data)
+		s.metrics.inserts++
+		newLog := getNewLog(log.ID, log.Data, at)
+		newLog.Fields = log.Fields
+		newLog.DocID = s.allocateDoc(log.ID)
+		newLog.Seq = s.allocateSeq()
 		s.addLog(newLog, true)
 		return
 	}
+	s.metrics.updates++
 	updatedLog := existingLog.copy()
-	updatedLog.Data = data
+	updatedLog.Data = log.Data
+	updatedLog.Fields = log.Fields
+	updatedLog.UpdatedAt = at
+	updatedLog.Version++
+	s.history.record(existingLog)
 	s.updateLog(existingLog, updatedLog)
 }
 
+// importLog stores a log read from a snapshot, keeping its timestamps and
+// version. A new log goes to the newest end of the eviction order, an
+// existing one is updated in place.
+func (s *Storage) importLog(log Log) {
+	s.observeID(log.ID)
+	s.metrics.ingested++
+	existingLog, err := s.getLogById(log.ID)
+	if err != nil {
+		s.metrics.inserts++
+		log.DocID = s.allocateDoc(log.ID)
+		log.Seq = s.allocateSeq()
+		s.addLog(log, true)
+	} else {
+		s.metrics.updates++
+		log.DocID = existingLog.DocID
+		log.Seq = existingLog.Seq
+		s.history.record(existingLog)
+		s.updateLog(existingLog, log)
+	}
+	s.cleanup()
+}
+
+// eachLog calls handle with every stored log in eviction order, oldest
+// first, stopping at the first error.
+func (s *Storage) eachLog(handle func(log Log) error) error {
+	s.expire()
+	return s.buffer.Each(func(id *LogID) error {
+		log, err := s.getLogById(*id)
+		if err != nil {
+			return nil
+		}
+		return handle(log)
+	})
+}
+
+// appendLog stores data under a newly assigned id and returns it.
+func (s *Storage) appendLog(data string) LogID {
+	id := s.reserveID()
+	s.putLog(Log{ID: id, Data: data})
+	s.cleanup()
+	return id
+}
+
+// reserveID returns the next assigned id. Assigned ids are increasing
+// numbers that stay above every numeric id a client has chosen, so they
+// don't clash. Once a client has used the largest one they wrap around to
+// 0, and ids still in use are skipped.
+func (s *Storage) reserveID() LogID {
+	for {
+		s.nextID++
+		id := LogID(strconv.FormatUint(s.nextID, 10))
+		if _, err := s.getLogById(id); err != nil {
+			return id
+		}
+	}
+}
+
+func (s *Storage) observeID(id LogID) {
+	n, err := strconv.ParseUint(string(id), 10, 64)
+	if err == nil && n > s.nextID {
+		s.nextID = n
+	}
+}
+
+// allocateDoc hands out the internal id for a new log, reusing the ids of
+// deleted logs first so DocIDs stay dense.
+func (s *Storage) allocateDoc(id LogID) DocID {
+	var doc DocID
+	if n := len(s.freeDocs); n > 0 {
+		doc = s.freeDocs[n-1]
+		s.freeDocs = s.freeDocs[:n-1]
+	} else {
+		doc = s.nextDoc
+		s.nextDoc++
+	}
+	s.docs[doc] = id
+	return doc
+}
+
+// allocateSeq numbers a new log, see Log.Seq.
+func (s *Storage) allocateSeq() uint64 {
+	s.nextSeq++
+	return s.nextSeq
+}
+
+func (s *Storage) releaseDoc(doc DocID) {
+	delete(s.docs, doc)
+	s.freeDocs = append(s.freeDocs, doc)
+}
+
+func (s *Storage) getLogByDoc(doc DocID) (Log, error) {
+	id, found := s.docs[doc]
+	if !found {
+		return Log{}, fmt.Errorf("document not found")
+	}
+	return s.getLogById(id)
+}
+
 func (s *Storage) updateLog(prevLog, updatedLog Log) {
+	s.forgetFlushed(prevLog.ID)
+	for _, hook := range s.hooks.onRemove {
+		hook(prevLog)
+	}
 	s.addLog(updatedLog, false)
 	opts := UpdateOpts{previous: &prevLog, current: &updatedLog}
 	s.index.update(opts)
 }
 
 func (s *Storage) addLog(log Log, updateBuffer bool) {
+	if prevLog, found := s.logsStorage[log.ID]; found {
+		s.metrics.storedBytes -= int64(len(prevLog.Data))
+	}
 	s.logsStorage[log.ID] = log
+	s.metrics.storedBytes += int64(len(log.Data))
+	s.expiry.set(log)
 	opts := UpdateOpts{current: &log}
 	s.index.update(opts)
 	if updateBuffer {
 		s.buffer.Enqueue(&log.ID)
 	}
 
-	s.cleanup()
+	for _, hook := range s.hooks.onIngest {
+		hook(log)
+	}
+}
 
+type SearchOptions struct {
+	// IncludeHistory also matches logs whose earlier versions contained
+	// the query.
+	IncludeHistory bool
+	// After skips every result up to and including this position.
+	After *searchPosition
 }
 
 func (s *Storage) getLogsByWord(word string, limit int) []Log {
-	logIds := s.index.getByKey(word)
-	if logIds == nil {
+	return s.searchLogs(word, limit, SearchOptions{})
+}
+
+func (s *Storage) searchLogs(word string, limit int, opts SearchOptions) []Log {
+	s.expire()
+	docs := s.lookupQuery(word)
+	if opts.IncludeHistory {
+		docs = unionEntries(docs, s.history.index.getByQuery(word))
+	}
+	if docs == nil {
 		return nil
 	}
+	return s.collectLogs(docs, limit, opts, nil)
+}
+
+// collectLogs resolves candidate docs to logs, keeps those accepted by
+// match (all of them when match is nil) and returns the first page of them
+// in result order.
+func (s *Storage) collectLogs(docs []DocID, limit int, opts SearchOptions, match func(log Log) bool) []Log {
 	var logs []Log
-	for i := len(logIds) - 1; i >= 0; i-- {
-		id := logIds[i]
-		log, err := s.getLogById(id)
+	for i := len(docs) - 1; i >= 0; i-- {
+		log, err := s.getLogByDoc(docs[i])
 		if err != nil {
 			continue
 		}
+		if match != nil && !match(log) {
+			continue
+		}
+		if opts.After != nil && !opts.After.before(getSearchPosition(log)) {
+			continue
+		}
 		logs = append(logs, log)
 	}
 	sort.Slice(logs, func(i, j int) bool {
-		return logs[i].CreatedAt.After(logs[j].CreatedAt)
+		return getSearchPosition(logs[i]).before(getSearchPosition(logs[j]))
 	})
 	if len(logs) < limit {
 		limit = len(logs)
@@ -82,10 +322,42 @@ func (s *Storage) getLogsByWord(word string, limit int) []Log {
 	return logs[:limit]
 }
 
+// getPatterns returns the n most common templates among the stored logs, or
+// among those matching query when it isn't empty.
+func (s *Storage) getPatterns(query string, n int) []PatternSummary {
+	s.expire()
+	if query == "" {
+		return s.patterns.top(n, nil)
+	}
+	matching := map[LogID]struct{}{}
+	for _, doc := range s.lookupQuery(query) {
+		if id, found := s.docs[doc]; found {
+			matching[id] = struct{}{}
+		}
+	}
+	return s.patterns.top(n, func(id LogID) bool {
+		_, found := matching[id]
+		return found
+	})
+}
+
+// getHistory returns every known version of a log, oldest first and ending
+// with the current one.
+func (s *Storage) getHistory(id LogID) ([]Log, error) {
+	current, err := s.getLogById(id)
+	if err != nil {
+		return nil, err
+	}
+	versions := append([]Log{}, s.history.get(id)...)
+	return append(versions, current), nil
+}
+
 func (s *Storage) getLogById(id LogID) (Log, error) {
 	log, found := s.logsStorage[id]
 	if !found {
-		return Log{}, fmt.Errorf("document not found")
+		if log, found = s.getFlushedLog(id); !found {
+			return Log{}, fmt.Errorf("document not found")
+		}
 	}
 	return log, nil
 }
@@ -97,16 +369,54 @@ func (s *Storage) truncate() {
 			break
 		}
 		s.deleteLogById(*lastElem)
+		s.metrics.evictions++
 	}
 }
 
 func (s *Storage) deleteLogById(id LogID) {
-	s.index.deletedByLogId(id)
-	delete(s.logsStorage, id)
+	log, found := s.logsStorage[id]
+	if found {
+		s.index.deletedByLogId(log.DocID)
+		delete(s.logsStorage, id)
+		s.metrics.storedBytes -= int64(len(log.Data))
+	} else if log, found = s.getFlushedLog(id); found {
+		s.forgetFlushed(id)
+	} else {
+		return
+	}
+	s.expiry.remove(id)
+	s.history.forget(log)
+	s.releaseDoc(log.DocID)
+	for _, hook := range s.hooks.onRemove {
+		hook(log)
+	}
 }
 
 func (s *Storage) cleanup() {
 	if s.buffer.Len() > s.capacity {
 		s.truncate()
 	}
+	s.expire()
+	// A failed flush leaves the logs in memory and is retried on the next
+	// write, FLUSH reports the error.
+	s.maybeFlush(s.now())
+}
+
+// expire drops logs created before the ttl, oldest first, whatever order
+// they arrived in. A zero ttl keeps logs until they are evicted by capacity.
+func (s *Storage) expire() {
+	if s.ttl <= 0 {
+		return
+	}
+	cutoff := s.now().Add(-s.ttl)
+	for {
+		oldest, found := s.expiry.oldest()
+		if !found || oldest.at.After(cutoff) {
+			break
+		}
+		s.expiry.remove(oldest.id)
+		s.buffer.Remove(oldest.id)
+		s.deleteLogById(oldest.id)
+		s.metrics.expirations++
+	}
 }
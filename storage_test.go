@@ -0,0 +1,372 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStorage_docIds(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		ids      []LogID
+		want     map[DocID]LogID
+	}{
+		{
+			"docs are dense",
+			3,
+			[]LogID{"a", "b", "c"},
+			map[DocID]LogID{0: "a", 1: "b", 2: "c"},
+		},
+		{
+			"updates keep their doc",
+			3,
+			[]LogID{"a", "b", "a"},
+			map[DocID]LogID{0: "a", 1: "b"},
+		},
+		{
+			"evicted docs are reused",
+			2,
+			[]LogID{"a", "b", "c", "d"},
+			map[DocID]LogID{0: "d", 2: "c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := getNewStore(tt.capacity)
+			for _, id := range tt.ids {
+				s.upsertLog(id, "hello "+string(id))
+			}
+			if !reflect.DeepEqual(s.docs, tt.want) {
+				t.Errorf("docs = %v, want %v", s.docs, tt.want)
+			}
+			for doc, id := range s.docs {
+				log, err := s.getLogByDoc(doc)
+				if err != nil || log.ID != id || log.DocID != doc {
+					t.Errorf("getLogByDoc(%d) = %v, %v, want log %s", doc, log, err, id)
+				}
+			}
+		})
+	}
+}
+
+func TestStorage_reserveID(t *testing.T) {
+	tests := []struct {
+		name      string
+		clientIds []LogID
+		want      LogID
+	}{
+		{"no client ids", nil, "1"},
+		{"numeric client ids", []LogID{"41", "7"}, "42"},
+		{"non numeric client ids are ignored", []LogID{"5", "9f1c2a4e", "abc"}, "6"},
+		{"largest client id", []LogID{"0", "18446744073709551615"}, "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := getNewStore(10)
+			for _, id := range tt.clientIds {
+				s.upsertLog(id, "hello")
+			}
+			if got := s.appendLog("hello"); got != tt.want {
+				t.Errorf("appendLog() = %v, want %v", got, tt.want)
+			}
+			if got := len(s.logsStorage); got != len(tt.clientIds)+1 {
+				t.Errorf("appendLog() replaced a client log, %d logs stored", got)
+			}
+		})
+	}
+}
+
+// referenceStore is a brute-force model of Storage with the whitespace
+// tokenizer: the logs in eviction order, scanned by every search.
+type referenceStore struct {
+	capacity int
+	logs     []Log
+	nextID   uint64
+}
+
+func (r *referenceStore) upsert(id LogID, data string) {
+	if n, err := strconv.ParseUint(string(id), 10, 64); err == nil && n > r.nextID {
+		r.nextID = n
+	}
+	for i := range r.logs {
+		if r.logs[i].ID == id {
+			r.logs[i].Data = data
+			return
+		}
+	}
+	r.logs = append(r.logs, Log{ID: id, Data: data})
+	if len(r.logs) > r.capacity {
+		r.logs = r.logs[len(r.logs)-r.capacity:]
+	}
+}
+
+func (r *referenceStore) append(data string) LogID {
+	id := LogID(strconv.FormatUint(r.nextID+1, 10))
+	r.upsert(id, data)
+	return id
+}
+
+// search returns the ids of the logs accepted by match, newest first.
+func (r *referenceStore) search(match func(data string) bool) []LogID {
+	ids := []LogID{}
+	for i := len(r.logs) - 1; i >= 0; i-- {
+		if match(r.logs[i].Data) {
+			ids = append(ids, r.logs[i].ID)
+		}
+	}
+	return ids
+}
+
+// modelInput drives a model run, from math/rand or from fuzzer bytes.
+type modelInput interface {
+	more() bool
+	choose(n int) int
+}
+
+type randInput struct {
+	rand  *rand.Rand
+	steps int
+}
+
+func (r *randInput) more() bool {
+	r.steps--
+	return r.steps >= 0
+}
+
+func (r *randInput) choose(n int) int {
+	return r.rand.Intn(n)
+}
+
+type bytesInput struct {
+	data []byte
+}
+
+func (b *bytesInput) more() bool {
+	return len(b.data) > 0
+}
+
+func (b *bytesInput) choose(n int) int {
+	if len(b.data) == 0 {
+		return 0
+	}
+	choice := int(b.data[0]) % n
+	b.data = b.data[1:]
+	return choice
+}
+
+var (
+	modelWords      = []string{"disk", "full", "cpu", "hot", "error", "ok"}
+	modelSubstrings = []string{"dis", "sk fu", "ot", "ERR", "k o", "u"}
+	modelPatterns   = []string{`^disk`, `err.r`, `(cpu|hot) ok`, `full$`, `o`}
+)
+
+func modelData(input modelInput) string {
+	words := make([]string, input.choose(4)+1)
+	for i := range words {
+		words[i] = modelWords[input.choose(len(modelWords))]
+	}
+	return strings.Join(words, " ")
+}
+
+// runModel applies random ADD, APPEND, SEARCH, FLUSH and COMPACT steps to
+// s and to a referenceStore, failing as soon as they disagree or s is no
+// longer consistent.
+func runModel(t *testing.T, s *Storage, input modelInput) {
+	t.Helper()
+	model := &referenceStore{capacity: s.capacity}
+	steps := []string{}
+	fail := func(format string, args ...interface{}) {
+		t.Helper()
+		t.Fatalf("after\n%s\n%s", strings.Join(steps, "\n"), fmt.Sprintf(format, args...))
+	}
+	for input.more() {
+		limit := input.choose(len(model.logs) + 2)
+		var got []Log
+		var want []LogID
+		switch input.choose(8) {
+		case 0, 1, 2:
+			id, data := LogID(strconv.Itoa(input.choose(12)+1)), modelData(input)
+			steps = append(steps, fmt.Sprintf("ADD %s %s", id, data))
+			s.upsertLog(id, data)
+			model.upsert(id, data)
+		case 3:
+			data := modelData(input)
+			steps = append(steps, "APPEND "+data)
+			if got, want := s.appendLog(data), model.append(data); got != want {
+				fail("appendLog() = %s, want %s", got, want)
+			}
+		case 4:
+			word := modelWords[input.choose(len(modelWords))]
+			steps = append(steps, fmt.Sprintf("SEARCH %s %d", word, limit))
+			got = s.getLogsByWord(word, limit)
+			want = model.search(func(data string) bool {
+				return containsWord(strings.Split(data, " "), word)
+			})
+		case 5:
+			text := modelSubstrings[input.choose(len(modelSubstrings))]
+			steps = append(steps, fmt.Sprintf("SEARCH *%s* %d", text, limit))
+			got = s.searchSubstring(text, limit, SearchOptions{})
+			want = model.search(func(data string) bool {
+				return strings.Contains(strings.ToLower(data), strings.ToLower(text))
+			})
+		case 6:
+			pattern := regexp.MustCompile(modelPatterns[input.choose(len(modelPatterns))])
+			steps = append(steps, fmt.Sprintf("SEARCH /%s/ %d", pattern, limit))
+			got = s.searchRegexp(pattern, limit, SearchOptions{})
+			want = model.search(pattern.MatchString)
+		case 7:
+			if s.segments == nil {
+				continue
+			}
+			steps = append(steps, "FLUSH", "COMPACT")
+			if _, err := s.flush(); err != nil {
+				fail("flush() error = %v", err)
+			}
+			if _, err := s.compact(false); err != nil {
+				fail("compact() error = %v", err)
+			}
+		}
+
+		if got != nil || want != nil {
+			if len(want) > limit {
+				want = want[:limit]
+			}
+			gotIDs := []LogID{}
+			for _, log := range got {
+				gotIDs = append(gotIDs, log.ID)
+			}
+			if !reflect.DeepEqual(gotIDs, want) {
+				fail("search found %v, want %v", gotIDs, want)
+			}
+		}
+		if got := s.buffer.Len(); got != len(model.logs) {
+			fail("buffer holds %d logs, want %d", got, len(model.logs))
+		}
+		for _, log := range model.logs {
+			if stored, err := s.getLogById(log.ID); err != nil || stored.Data != log.Data {
+				fail("getLogById(%s) = %q, %v, want %q", log.ID, stored.Data, err, log.Data)
+			}
+		}
+		if issues := s.check(); len(issues) > 0 {
+			fail("check() = %q", issues)
+		}
+	}
+}
+
+func containsWord(words []string, word string) bool {
+	for _, other := range words {
+		if other == word {
+			return true
+		}
+	}
+	return false
+}
+
+// modelStore returns a store in one of the configurations the model tests
+// cover. Its clock is stopped, so the result order only depends on the
+// order logs arrive in.
+func modelStore(t *testing.T, config int, capacity int) *Storage {
+	s := getNewStore(capacity)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	switch config % 3 {
+	case 1:
+		s.enableTrigrams()
+	case 2:
+		s.enableTrigrams()
+		if err := s.enableSegments(t.TempDir(), 4, 0); err != nil {
+			t.Fatal(err)
+		}
+		s.segments.merge = mergeConfig{factor: 2, maxDeleted: 0.3}
+	}
+	return s
+}
+
+func TestStorage_model(t *testing.T) {
+	tests := []struct {
+		name   string
+		config int
+	}{
+		{"memory", 0},
+		{"trigrams", 1},
+		{"segments", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for seed := int64(1); seed <= 20; seed++ {
+				r := rand.New(rand.NewSource(seed))
+				s := modelStore(t, tt.config, r.Intn(8)+2)
+				runModel(t, s, &randInput{rand: r, steps: 200})
+			}
+		})
+	}
+}
+
+func FuzzStorage_model(f *testing.F) {
+	f.Add([]byte{0, 3, 0, 1, 2, 3, 4, 0, 4, 0, 5, 1, 5, 2, 6, 3, 7})
+	f.Add([]byte{2, 5, 1, 7, 0, 0, 1, 2, 0, 7, 1, 1, 0, 7, 0, 2, 0, 0, 3, 1, 7, 4, 2, 5})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		input := &bytesInput{data: data}
+		s := modelStore(t, input.choose(3), input.choose(8)+2)
+		runModel(t, s, input)
+	})
+}
+
+func TestStorage_clock(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	s := getNewStore(10)
+	s.ttl = time.Minute
+	s.now = func() time.Time { return now }
+
+	for _, id := range []LogID{"b", "c", "a"} {
+		s.upsertLog(id, "tick")
+	}
+	s.upsertLog("c", "tock")
+	if got := ids(s.getLogsByWord("tick", 5)); !reflect.DeepEqual(got, []LogID{"a", "b"}) {
+		t.Errorf("logs of the same instant = %v, want newest first", got)
+	}
+	if log, _ := s.getLogById("c"); !log.CreatedAt.Equal(start) || log.Seq != 2 {
+		t.Errorf("update changed CreatedAt or Seq: %+v", log)
+	}
+
+	now = start.Add(30 * time.Second)
+	s.upsertLogs([]Log{{ID: "d", Data: "tick"}, {ID: "e", Data: "tick", CreatedAt: start.Add(-10 * time.Second)}})
+	if got := ids(s.getLogsByWord("tick", 5)); !reflect.DeepEqual(got, []LogID{"d", "a", "b", "e"}) {
+		t.Errorf("search = %v, want the back-filled log last", got)
+	}
+
+	// Logs expire by their own time, not with the logs that arrived around
+	// them, so a log back-filled past the ttl goes right away.
+	s.upsertLogs([]Log{{ID: "f", Data: "tick"}, {ID: "g", Data: "tick", CreatedAt: start.Add(-time.Hour)}})
+	if got := ids(s.getLogsByWord("tick", 5)); !reflect.DeepEqual(got, []LogID{"f", "d", "a", "b", "e"}) {
+		t.Errorf("search = %v, want the expired back-filled log gone", got)
+	}
+	now = start.Add(61 * time.Second)
+	if got := ids(s.getLogsByWord("tick", 5)); !reflect.DeepEqual(got, []LogID{"f", "d"}) {
+		t.Errorf("search after the ttl = %v, want [f d]", got)
+	}
+	s.upsertLogs([]Log{{ID: "h", Data: "tick", CreatedAt: start.Add(70 * time.Second)}})
+	now = start.Add(91 * time.Second)
+	if got := ids(s.getLogsByWord("tick", 5)); !reflect.DeepEqual(got, []LogID{"h"}) {
+		t.Errorf("search after the ttl = %v, want [h]", got)
+	}
+	if issues := s.check(); len(issues) > 0 {
+		t.Errorf("check() = %q", issues)
+	}
+}
+
+func ids(logs []Log) []LogID {
+	ids := []LogID{}
+	for _, log := range logs {
+		ids = append(ids, log.ID)
+	}
+	return ids
+}
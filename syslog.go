@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var syslogSeverities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// defaultSyslogPriority is user.notice, which RFC 3164 gives messages
+// without a valid priority.
+const defaultSyslogPriority = 13
+
+type syslogMessage struct {
+	Facility  int
+	Severity  int
+	Timestamp time.Time
+	Hostname  string
+	AppName   string
+	ProcID    string
+	MsgID     string
+	Message   string
+}
+
+// fields are the values a message is indexed by next to the words of its
+// text, searched as "{host=web1}". Empty ones are left out.
+func (m syslogMessage) fields() map[string]string {
+	fields := map[string]string{
+		"facility": syslogFacilities[m.Facility],
+		"severity": syslogSeverities[m.Severity],
+	}
+	if m.Hostname != "" {
+		fields["host"] = m.Hostname
+	}
+	if m.AppName != "" {
+		fields["app"] = m.AppName
+	}
+	return fields
+}
+
+// parseSyslog reads an RFC 5424 message, or failing that an RFC 3164 one.
+// Like an RFC 3164 relay it never rejects a message: whatever it can't read
+// becomes part of the text. A zero Timestamp means the message had none.
+func parseSyslog(line string, loc *time.Location, now time.Time) syslogMessage {
+	priority, rest := defaultSyslogPriority, line
+	if end := strings.IndexByte(line, '>'); strings.HasPrefix(line, "<") && end >= 2 && end <= 4 {
+		if n, err := strconv.Atoi(line[1:end]); err == nil && isDigits(line[1:end]) && n < len(syslogFacilities)*8 {
+			priority, rest = n, line[end+1:]
+		}
+	}
+	msg := syslogMessage{Facility: priority / 8, Severity: priority % 8}
+	if strings.HasPrefix(rest, "1 ") && parseRFC5424(&msg, rest[2:]) {
+		return msg
+	}
+	parseRFC3164(&msg, rest, loc, now)
+	return msg
+}
+
+// parseRFC5424 reads the header after the version:
+//
+//	TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+//
+// with "-" for missing values. Structured data isn't kept.
+func parseRFC5424(msg *syslogMessage, rest string) bool {
+	header := make([]string, 5)
+	for i := range header {
+		end := strings.IndexByte(rest, ' ')
+		if end <= 0 {
+			return false
+		}
+		if header[i] = rest[:end]; header[i] == "-" {
+			header[i] = ""
+		}
+		rest = rest[end+1:]
+	}
+	if header[0] != "" {
+		t, err := time.Parse(time.RFC3339Nano, header[0])
+		if err != nil {
+			return false
+		}
+		msg.Timestamp = t
+	}
+	end := structuredDataEnd(rest)
+	if end < 0 {
+		return false
+	}
+	msg.Hostname, msg.AppName, msg.ProcID, msg.MsgID = header[1], header[2], header[3], header[4]
+	msg.Message = strings.TrimPrefix(strings.TrimPrefix(rest[end:], " "), "\ufeff")
+	return true
+}
+
+// structuredDataEnd returns where the structured data that data starts
+// with ends, or -1 when there is none. Values are quoted and may hold
+// escaped quotes and brackets.
+func structuredDataEnd(data string) int {
+	if strings.HasPrefix(data, "-") {
+		return 1
+	}
+	i := 0
+	for i < len(data) && data[i] == '[' {
+		quoted := false
+		for i++; i < len(data) && (quoted || data[i] != ']'); i++ {
+			switch {
+			case quoted && data[i] == '\\':
+				i++
+			case data[i] == '"':
+				quoted = !quoted
+			}
+		}
+		if i >= len(data) {
+			return -1
+		}
+		i++
+	}
+	if i == 0 {
+		return -1
+	}
+	return i
+}
+
+// parseRFC3164 reads the BSD format, "Mmm dd hh:mm:ss HOSTNAME TAG: MSG".
+// Some senders leave out the hostname, so a first word that already reads
+// as a tag, such as "sshd[42]:", is taken as one.
+func parseRFC3164(msg *syslogMessage, rest string, loc *time.Location, now time.Time) {
+	msg.Message = rest
+	if len(rest) < len(time.Stamp) {
+		return
+	}
+	t, err := time.ParseInLocation(time.Stamp, rest[:len(time.Stamp)], loc)
+	if err != nil {
+		return
+	}
+	msg.Timestamp = inferYear(t, now)
+	rest = strings.TrimPrefix(rest[len(time.Stamp):], " ")
+	if host, after, found := cutSpace(rest); found && !isSyslogTag(host) {
+		msg.Hostname, rest = host, after
+	}
+	msg.Message = rest
+	tag, after, found := cutSpace(rest)
+	if !found {
+		tag, after = rest, ""
+	}
+	if !isSyslogTag(tag) {
+		return
+	}
+	tag = strings.TrimSuffix(tag, ":")
+	if start := strings.IndexByte(tag, '['); start >= 0 {
+		tag, msg.ProcID = tag[:start], tag[start+1:len(tag)-1]
+	}
+	msg.AppName, msg.Message = tag, after
+}
+
+// isSyslogTag reports whether word is "name:" or "name[pid]:".
+func isSyslogTag(word string) bool {
+	if !strings.HasSuffix(word, ":") || len(word) < 2 {
+		return false
+	}
+	word = word[:len(word)-1]
+	if start := strings.IndexByte(word, '['); start >= 0 {
+		if start == 0 || !strings.HasSuffix(word, "]") {
+			return false
+		}
+		word = word[:start]
+	}
+	return !strings.ContainsAny(word, "[]")
+}
+
+func cutSpace(s string) (string, string, bool) {
+	end := strings.IndexByte(s, ' ')
+	if end < 0 {
+		return s, "", false
+	}
+	return s[:end], s[end+1:], true
+}
+
+// splitSyslogFrames splits a TCP stream into messages framed as RFC 6587
+// allows: prefixed with their length in bytes, or ended by a newline.
+func splitSyslogFrames(data []byte, atEOF bool) (int, []byte, error) {
+	if len(data) == 0 || data[0] < '1' || data[0] > '9' {
+		return bufio.ScanLines(data, atEOF)
+	}
+	space := bytes.IndexByte(data, ' ')
+	if space < 0 {
+		if atEOF || len(data) > 10 {
+			return 0, nil, fmt.Errorf("invalid syslog frame length")
+		}
+		return 0, nil, nil
+	}
+	n, err := strconv.Atoi(string(data[:space]))
+	if err != nil || n > maxLineLength {
+		return 0, nil, fmt.Errorf("invalid syslog frame length %q", data[:space])
+	}
+	if len(data) < space+1+n {
+		if atEOF {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		return 0, nil, nil
+	}
+	return space + 1 + n, data[space+1 : space+1+n], nil
+}
+
+// syslogReceiver stores the syslog messages it receives over UDP, one per
+// datagram, and over TCP.
+type syslogReceiver struct {
+	store *Storage
+	// location is the zone of RFC 3164 times, which don't have one.
+	location  *time.Location
+	logOutput io.Writer
+	packets   net.PacketConn
+	listener  net.Listener
+	wg        sync.WaitGroup
+}
+
+func getNewSyslogReceiver(store *Storage, logOutput io.Writer) *syslogReceiver {
+	return &syslogReceiver{store: store, location: time.Local, logOutput: logOutput}
+}
+
+// listen starts receiving on the addresses that aren't empty.
+func (r *syslogReceiver) listen(udpAddr, tcpAddr string) error {
+	var err error
+	if udpAddr != "" {
+		if r.packets, err = net.ListenPacket("udp", udpAddr); err != nil {
+			return err
+		}
+		r.wg.Add(1)
+		go r.readPackets()
+	}
+	if tcpAddr != "" {
+		if r.listener, err = net.Listen("tcp", tcpAddr); err != nil {
+			r.close()
+			return err
+		}
+		r.wg.Add(1)
+		go r.acceptConns()
+	}
+	return nil
+}
+
+// close stops listening. Open TCP connections are served until their
+// senders close them.
+func (r *syslogReceiver) close() {
+	if r.packets != nil {
+		r.packets.Close()
+	}
+	if r.listener != nil {
+		r.listener.Close()
+	}
+	r.wg.Wait()
+}
+
+func (r *syslogReceiver) readPackets() {
+	defer r.wg.Done()
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := r.packets.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		r.receive(string(buf[:n]), from)
+	}
+}
+
+func (r *syslogReceiver) acceptConns() {
+	defer r.wg.Done()
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		go r.serveConn(conn)
+	}
+}
+
+func (r *syslogReceiver) serveConn(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	scanner.Split(splitSyslogFrames)
+	for scanner.Scan() {
+		r.receive(scanner.Text(), conn.RemoteAddr())
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(r.logOutput, "syslog connection from %s: %v\n", conn.RemoteAddr(), err)
+	}
+}
+
+// receive stores the text of one message under an assigned id, with its
+// fields. Messages without a hostname get the sender's address, as an
+// RFC 3164 relay would add.
+func (r *syslogReceiver) receive(line string, from net.Addr) {
+	line = strings.TrimRight(line, "\r\n\x00")
+	if line == "" {
+		return
+	}
+	// Stored logs are single lines.
+	line = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(line)
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	msg := parseSyslog(line, r.location, r.store.now())
+	if msg.Hostname == "" && from != nil {
+		if host, _, err := net.SplitHostPort(from.String()); err == nil {
+			msg.Hostname = host
+		}
+	}
+	r.store.upsertLogs([]Log{{ID: r.store.reserveID(), Data: msg.Message, Fields: msg.fields(), CreatedAt: msg.Timestamp}})
+}
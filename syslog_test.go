@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseSyslog(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		line string
+		want syslogMessage
+	}{
+		{
+			"rfc5424",
+			"<34>1 2024-04-30T22:14:15.003Z web1 su 42 ID47 - 'su root' failed",
+			syslogMessage{4, 2, time.Date(2024, 4, 30, 22, 14, 15, 3000000, time.UTC), "web1", "su", "42", "ID47", "'su root' failed"},
+		},
+		{
+			"rfc5424 structured data",
+			"<165>1 2024-04-30T22:14:15+02:00 web1 evntslog - ID47 [a@1 x=\"3\" y=\"q\\\"]\"][b@1] \ufeffstarted",
+			syslogMessage{20, 5, time.Date(2024, 4, 30, 20, 14, 15, 0, time.UTC), "web1", "evntslog", "", "ID47", "started"},
+		},
+		{
+			"rfc5424 nil values",
+			"<14>1 - - - - - -",
+			syslogMessage{1, 6, time.Time{}, "", "", "", "", ""},
+		},
+		{
+			"rfc3164",
+			"<38>Apr 30 22:14:15 web1 sshd[1234]: Accepted publickey for root",
+			syslogMessage{4, 6, time.Date(2024, 4, 30, 22, 14, 15, 0, time.UTC), "web1", "sshd", "1234", "", "Accepted publickey for root"},
+		},
+		{
+			"rfc3164 without hostname",
+			"<13>Dec 31 23:59:59 cron: job done",
+			syslogMessage{1, 5, time.Date(2023, 12, 31, 23, 59, 59, 0, time.UTC), "", "cron", "", "", "job done"},
+		},
+		{
+			"rfc3164 without tag",
+			"<13>May  1 11:00:00 web1 kernel panic",
+			syslogMessage{1, 5, time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC), "web1", "", "", "", "kernel panic"},
+		},
+		{
+			"rfc3164 without timestamp",
+			"<11>disk failure",
+			syslogMessage{1, 3, time.Time{}, "", "", "", "", "disk failure"},
+		},
+		{
+			"no priority",
+			"hello world",
+			syslogMessage{1, 5, time.Time{}, "", "", "", "", "hello world"},
+		},
+		{
+			"invalid priority",
+			"<192>1 - - - - - - hi",
+			syslogMessage{1, 5, time.Time{}, "", "", "", "", "<192>1 - - - - - - hi"},
+		},
+		{
+			"invalid rfc5424 falls back",
+			"<14>1 yesterday web1 app - - - hi",
+			syslogMessage{1, 6, time.Time{}, "", "", "", "", "1 yesterday web1 app - - - hi"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseSyslog(tt.line, time.UTC, now)
+			if !got.Timestamp.Equal(tt.want.Timestamp) {
+				t.Errorf("parseSyslog() Timestamp = %v, want %v", got.Timestamp, tt.want.Timestamp)
+			}
+			got.Timestamp = tt.want.Timestamp
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSyslog() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_syslogMessage_fields(t *testing.T) {
+	msg := syslogMessage{Facility: 4, Severity: 3, Hostname: "web1", Message: "denied"}
+	want := map[string]string{"facility": "auth", "severity": "err", "host": "web1"}
+	if got := msg.fields(); !reflect.DeepEqual(got, want) {
+		t.Errorf("fields() = %v, want %v", got, want)
+	}
+}
+
+func Test_splitSyslogFrames(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  string
+		want    []string
+		wantErr bool
+	}{
+		{"newlines", "<13>a\n<13>b\r\n<13>c", []string{"<13>a", "<13>b", "<13>c"}, false},
+		{"octet counting", "5 <13>a14 <13>1 - - - b\n", []string{"<13>a", "<13>1 - - - b\n"}, false},
+		{"mixed", "5 <13>a<13>b\n", []string{"<13>a", "<13>b"}, false},
+		{"truncated", "9 <13>a", []string{}, true},
+		{"bad length", "12345678901 <13>a", []string{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scanner := bufio.NewScanner(strings.NewReader(tt.stream))
+			scanner.Split(splitSyslogFrames)
+			got := []string{}
+			for scanner.Scan() {
+				got = append(got, scanner.Text())
+			}
+			if !reflect.DeepEqual(got, tt.want) || (scanner.Err() != nil) != tt.wantErr {
+				t.Errorf("frames = %q, %v, want %q", got, scanner.Err(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSyslogReceiver(t *testing.T) {
+	store := getNewStore(10)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	receiver := getNewSyslogReceiver(store, io.Discard)
+	receiver.location = time.UTC
+	if err := receiver.listen("127.0.0.1:0", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.close()
+
+	udp, err := net.Dial("udp", receiver.packets.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	if _, err := udp.Write([]byte("<38>Apr 30 22:14:15 web1 sshd[1]: Accepted publickey\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitForLogs(t, store, 1)
+
+	tcp, err := net.Dial("tcp", receiver.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := "<11>1 2024-04-30T22:14:16Z web2 nginx - - - upstream timed out\n" +
+		"49 <12>1 2024-04-30T22:14:17Z web1 nginx - - - retry" +
+		"<13>no\rheader\n"
+	if _, err := tcp.Write([]byte(stream)); err != nil {
+		t.Fatal(err)
+	}
+	tcp.Close()
+	waitForLogs(t, store, 4)
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"{host=web1}", []string{"retry", "Accepted publickey"}},
+		{"{app=nginx}", []string{"retry", "upstream timed out"}},
+		{"{severity=notice}", []string{"no header"}},
+		{"host=web1", []string{}},
+	}
+	for _, tt := range tests {
+		got := []string{}
+		for _, log := range store.getLogsByWord(tt.query, 10) {
+			got = append(got, log.Data)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SEARCH %s = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+	log, _ := store.getLogById("1")
+	if want := time.Date(2024, 4, 30, 22, 14, 15, 0, time.UTC); !log.CreatedAt.Equal(want) {
+		t.Errorf("CreatedAt = %v, want the syslog time %v", log.CreatedAt, want)
+	}
+	if want := map[string]string{"facility": "auth", "severity": "info", "host": "web1", "app": "sshd"}; !reflect.DeepEqual(log.Fields, want) {
+		t.Errorf("Fields = %v, want %v", log.Fields, want)
+	}
+}
+
+func waitForLogs(t *testing.T, store *Storage, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		store.mu.Lock()
+		stored := store.buffer.Len()
+		store.mu.Unlock()
+		if stored >= n {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %d logs", n)
+}
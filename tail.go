@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultPollInterval = 250 * time.Millisecond
+
+// Tail follows a file that is being appended to, the way `tail -F` does.
+// It reopens the path when the file is rotated and starts over from the
+// beginning when the file is truncated.
+type Tail struct {
+	path         string
+	pollInterval time.Duration
+	file         *os.File
+	info         os.FileInfo
+	reader       *bufio.Reader
+	offset       int64
+	partial      string
+}
+
+func getNewTail(path string) *Tail {
+	return &Tail{
+		path:         path,
+		pollInterval: defaultPollInterval,
+	}
+}
+
+// follow calls handle for every complete line in the file until stop is
+// closed. A nil stop channel follows forever.
+func (t *Tail) follow(handle func(line string), stop <-chan struct{}) error {
+	defer t.close()
+	for {
+		if t.file == nil {
+			if err := t.open(); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if t.file != nil {
+			if err := t.readLines(handle); err != nil {
+				return err
+			}
+			if err := t.checkRotation(handle); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(t.pollInterval):
+		}
+	}
+}
+
+func (t *Tail) open() error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	t.file = f
+	t.info = info
+	t.reader = bufio.NewReader(f)
+	t.offset = 0
+	t.partial = ""
+	return nil
+}
+
+func (t *Tail) close() {
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+}
+
+func (t *Tail) readLines(handle func(line string)) error {
+	for {
+		chunk, err := t.reader.ReadString('\n')
+		t.offset += int64(len(chunk))
+		if err == io.EOF {
+			t.partial += chunk
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line := strings.TrimRight(t.partial+chunk, "\r\n")
+		t.partial = ""
+		if line != "" {
+			handle(line)
+		}
+	}
+}
+
+// checkRotation reopens the path when it now points at a different file and
+// rewinds when the current file shrank below what was already read.
+func (t *Tail) checkRotation(handle func(line string)) error {
+	info, err := os.Stat(t.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(info, t.info) {
+		if err := t.readLines(handle); err != nil {
+			return err
+		}
+		// Nothing more is written to the rotated file, so its unterminated
+		// last line is complete.
+		if line := strings.TrimRight(t.partial, "\r\n"); line != "" {
+			handle(line)
+		}
+		t.close()
+		return t.open()
+	}
+	if info.Size() < t.offset {
+		if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		t.reader.Reset(t.file)
+		t.offset = 0
+		t.partial = ""
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTail_follow(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps func(path string, wait func())
+		want  []string
+	}{
+		{
+			"appended lines",
+			func(path string, wait func()) {
+				appendToFile(t, path, "hello world\n")
+				wait()
+				appendToFile(t, path, "hello ")
+				wait()
+				appendToFile(t, path, "again\n")
+			},
+			[]string{"hello world", "hello again"},
+		},
+		{
+			"truncated file",
+			func(path string, wait func()) {
+				appendToFile(t, path, "first line\nsecond line\n")
+				wait()
+				if err := os.Truncate(path, 0); err != nil {
+					t.Fatal(err)
+				}
+				wait()
+				appendToFile(t, path, "third\n")
+			},
+			[]string{"first line", "second line", "third"},
+		},
+		{
+			"rotated file",
+			func(path string, wait func()) {
+				appendToFile(t, path, "before rotation\n")
+				wait()
+				if err := os.Rename(path, path+".1"); err != nil {
+					t.Fatal(err)
+				}
+				appendToFile(t, path, "after rotation\n")
+			},
+			[]string{"before rotation", "after rotation"},
+		},
+		{
+			"rotated file without a final newline",
+			func(path string, wait func()) {
+				appendToFile(t, path, "before rotation\nlast words")
+				wait()
+				if err := os.Rename(path, path+".1"); err != nil {
+					t.Fatal(err)
+				}
+				appendToFile(t, path, "after rotation\n")
+			},
+			[]string{"before rotation", "last words", "after rotation"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "app.log")
+			appendToFile(t, path, "")
+
+			var mu sync.Mutex
+			var got []string
+			tail := getNewTail(path)
+			tail.pollInterval = time.Millisecond
+			stop := make(chan struct{})
+			done := make(chan error)
+			go func() {
+				done <- tail.follow(func(line string) {
+					mu.Lock()
+					got = append(got, line)
+					mu.Unlock()
+				}, stop)
+			}()
+
+			wait := func() { time.Sleep(20 * time.Millisecond) }
+			tt.steps(path, wait)
+			wait()
+			close(stop)
+			if err := <-done; err != nil {
+				t.Fatalf("follow() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("follow() lines = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func appendToFile(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxLayoutSlack is how much longer than its layout a timestamp may be, for
+// fractional seconds and zone names the layout doesn't spell out.
+const maxLayoutSlack = 16
+
+// timestampParser reads the time of an event from the start of a log line.
+// Times without a zone are read in loc, and times without a year are put in
+// the latest year that doesn't make them more than a day later than now.
+type timestampParser func(data string, loc *time.Location, now time.Time) (time.Time, bool)
+
+var timestampFormats = map[string]timestampParser{
+	"rfc3339": parseLayouts(time.RFC3339Nano, "2006-01-02 15:04:05Z07:00", "2006-01-02 15:04:05"),
+	"syslog":  parseLayouts(time.Stamp),
+	"apache":  parseApache,
+	"nginx":   parseLayouts("2006/01/02 15:04:05"),
+	"epoch":   parseEpoch,
+}
+
+// autoTimestampFormats is the order the built-in formats are tried in with
+// --timestamps auto.
+var autoTimestampFormats = []string{"rfc3339", "syslog", "apache", "nginx", "epoch"}
+
+func getTimestampFormat(name string) (timestampParser, error) {
+	parser, found := timestampFormats[name]
+	if !found {
+		names := []string{}
+		for name := range timestampFormats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown timestamp format %q, expected one of auto, %s", name, strings.Join(names, ", "))
+	}
+	return parser, nil
+}
+
+// timeExtractor sets the time of ingested logs from the timestamp their
+// text starts with.
+type timeExtractor struct {
+	parsers  []timestampParser
+	location *time.Location
+}
+
+// getTimeExtractor tries the Go layouts first and then the comma-separated
+// built-in formats, or all of them for "auto". It returns nil when there is
+// nothing to try.
+func getTimeExtractor(formats string, layouts []string) (*timeExtractor, error) {
+	extractor := &timeExtractor{location: time.Local}
+	if len(layouts) > 0 {
+		extractor.parsers = append(extractor.parsers, parseLayouts(layouts...))
+	}
+	names := []string{}
+	if formats == "auto" {
+		names = autoTimestampFormats
+	} else if formats != "" {
+		names = strings.Split(formats, ",")
+	}
+	for _, name := range names {
+		parser, err := getTimestampFormat(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		extractor.parsers = append(extractor.parsers, parser)
+	}
+	if len(extractor.parsers) == 0 {
+		return nil, nil
+	}
+	return extractor, nil
+}
+
+func (e *timeExtractor) extract(data string, now time.Time) (time.Time, bool) {
+	data = strings.TrimLeft(data, " \t")
+	for _, parse := range e.parsers {
+		if t, ok := parse(data, e.location, now); ok {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseLayouts matches the start of data against Go time layouts. As a
+// timestamp may hold spaces, every prefix of data that ends before a space
+// and isn't much longer than the layout is tried, longest first.
+func parseLayouts(layouts ...string) timestampParser {
+	return func(data string, loc *time.Location, now time.Time) (time.Time, bool) {
+		for _, layout := range layouts {
+			limit := len(layout) + maxLayoutSlack
+			ends := []int{}
+			for end := 1; end <= len(data) && end <= limit; end++ {
+				if end == len(data) || data[end] == ' ' {
+					ends = append(ends, end)
+				}
+			}
+			for i := len(ends) - 1; i >= 0; i-- {
+				if t, err := time.ParseInLocation(layout, data[:ends[i]], loc); err == nil {
+					return inferYear(t, now), true
+				}
+			}
+		}
+		return time.Time{}, false
+	}
+}
+
+// inferYear places a time parsed without a year in the latest year that
+// doesn't put it more than a day after now.
+func inferYear(t, now time.Time) time.Time {
+	if t.Year() != 0 {
+		return t
+	}
+	t = t.AddDate(now.Year(), 0, 0)
+	if t.After(now.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t
+}
+
+const apacheLayout = "02/Jan/2006:15:04:05 -0700"
+
+// parseApache reads the "[02/Jan/2006:15:04:05 -0700]" time of the common
+// and combined log formats, which Apache and nginx access logs use. It
+// follows the host, ident and user fields, or starts the line.
+func parseApache(data string, loc *time.Location, now time.Time) (time.Time, bool) {
+	start := strings.IndexByte(data, '[')
+	if start < 0 || strings.Count(data[:start], " ") > 3 {
+		return time.Time{}, false
+	}
+	end := strings.IndexByte(data[start:], ']')
+	if end < 0 {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(apacheLayout, data[start+1:start+end])
+	return t, err == nil
+}
+
+// parseEpoch reads a leading Unix time in seconds, milliseconds,
+// microseconds or nanoseconds, told apart by the number of digits. Seconds
+// may have a fraction.
+func parseEpoch(data string, loc *time.Location, now time.Time) (time.Time, bool) {
+	field := data
+	if end := strings.IndexByte(data, ' '); end >= 0 {
+		field = data[:end]
+	}
+	whole, fraction := field, ""
+	if dot := strings.IndexByte(field, '.'); dot >= 0 {
+		whole, fraction = field[:dot], field[dot+1:]
+	}
+	if !isDigits(whole) || (fraction != "" && (len(whole) != 10 || !isDigits(fraction) || len(fraction) > 9)) {
+		return time.Time{}, false
+	}
+	value, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	switch len(whole) {
+	case 10:
+		nanos, _ := strconv.ParseInt((fraction + "000000000")[:9], 10, 64)
+		return time.Unix(value, nanos), true
+	case 13:
+		return time.UnixMilli(value), true
+	case 16:
+		return time.UnixMicro(value), true
+	case 19:
+		return time.Unix(0, value), true
+	}
+	return time.Time{}, false
+}
+
+func isDigits(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
+
+// layoutFlag collects the repeated --time-layout flags.
+type layoutFlag []string
+
+func (l *layoutFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *layoutFlag) Set(layout string) error {
+	*l = append(*l, layout)
+	return nil
+}
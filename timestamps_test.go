@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func Test_timeExtractor_extract(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		formats string
+		layouts []string
+		data    string
+		want    time.Time
+		wantOK  bool
+	}{
+		{"rfc3339", "auto", nil, "2024-04-30T10:00:00.5+02:00 GET /", time.Date(2024, 4, 30, 8, 0, 0, 500000000, time.UTC), true},
+		{"rfc3339 with a space", "auto", nil, "2024-04-30 10:00:00,123 INFO start", time.Date(2024, 4, 30, 10, 0, 0, 123000000, time.UTC), true},
+		{"leading blanks", "auto", nil, "  2024-04-30T10:00:00Z x", time.Date(2024, 4, 30, 10, 0, 0, 0, time.UTC), true},
+		{"syslog", "auto", nil, "Apr 30 10:00:00 host sshd[1]: ok", time.Date(2024, 4, 30, 10, 0, 0, 0, time.UTC), true},
+		{"syslog padded day", "syslog", nil, "May  2 00:00:00 host x", time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC), true},
+		{"syslog last year", "syslog", nil, "Dec 31 23:59:59 host x", time.Date(2023, 12, 31, 23, 59, 59, 0, time.UTC), true},
+		{"apache", "auto", nil, `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 2326`, time.Date(2000, 10, 10, 20, 55, 36, 0, time.UTC), true},
+		{"apache bracket too late", "apache", nil, `a b c d [10/Oct/2000:13:55:36 -0700]`, time.Time{}, false},
+		{"nginx", "auto", nil, "2024/04/30 10:00:00 [error] 1#1: boom", time.Date(2024, 4, 30, 10, 0, 0, 0, time.UTC), true},
+		{"epoch seconds", "auto", nil, "1714564800 start", now, true},
+		{"epoch fraction", "epoch", nil, "1714564800.25 start", now.Add(250 * time.Millisecond), true},
+		{"epoch millis", "epoch", nil, "1714564800123 start", now.Add(123 * time.Millisecond), true},
+		{"epoch nanos", "epoch", nil, "1714564800000000001", now.Add(1), true},
+		{"short number", "auto", nil, "12345 requests", time.Time{}, false},
+		{"no timestamp", "auto", nil, "GET / 200", time.Time{}, false},
+		{"format not enabled", "epoch", nil, "2024-04-30T10:00:00Z x", time.Time{}, false},
+		{"layout", "", []string{"02.01.2006 15:04"}, "30.04.2024 10:00 boom", time.Date(2024, 4, 30, 10, 0, 0, 0, time.UTC), true},
+		{"layout before formats", "epoch", []string{"Jan 2 15:04"}, "Apr 30 10:00 boom", time.Date(2024, 4, 30, 10, 0, 0, 0, time.UTC), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extractor, err := getTimeExtractor(tt.formats, tt.layouts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			extractor.location = time.UTC
+			got, ok := extractor.extract(tt.data, now)
+			if ok != tt.wantOK || !got.Equal(tt.want) {
+				t.Errorf("extract() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func Test_getTimeExtractor(t *testing.T) {
+	if extractor, err := getTimeExtractor("", nil); extractor != nil || err != nil {
+		t.Errorf("getTimeExtractor() = %v, %v, want nothing to extract", extractor, err)
+	}
+	if extractor, err := getTimeExtractor("syslog, epoch", nil); err != nil || len(extractor.parsers) != 2 {
+		t.Errorf("getTimeExtractor() = %v, %v, want 2 formats", extractor, err)
+	}
+	if _, err := getTimeExtractor("rfc3339,iso", nil); err == nil {
+		t.Errorf("getTimeExtractor() should reject unknown formats")
+	}
+}
+
+func TestStorage_timestamps(t *testing.T) {
+	store := getNewStore(10)
+	store.timestamps, _ = getTimeExtractor("auto", nil)
+	output := &bytes.Buffer{}
+	session := getNewSession(store, output)
+	for _, command := range []string{
+		"ADD 1 2024-05-01T10:00:00Z disk a",
+		"ADD 2 2024-05-01T12:00:00Z disk b",
+		"ADD 3 2024-05-01T11:00:00Z disk c",
+		"ADDAT 4 2024-05-01T09:00:00Z 2024-05-01T13:00:00Z disk d",
+		"SEARCH disk 5",
+	} {
+		session.execute(command)
+	}
+	if got, want := output.String(), "2 3 1 4\r\n"; got != want {
+		t.Errorf("SEARCH = %q, want %q", got, want)
+	}
+	log, _ := store.getLogById("3")
+	if want := time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC); !log.CreatedAt.Equal(want) || log.Data != "2024-05-01T11:00:00Z disk c" {
+		t.Errorf("getLogById() = %+v, want CreatedAt %v and the data unchanged", log, want)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Tokenizer turns log data or a query into index keys.
+type Tokenizer func(data string) []string
+
+// Token is a normalised term together with the byte range of data it was
+// read from.
+type Token struct {
+	Term  string
+	Start int
+	End   int
+}
+
+// Analyzer splits data into tokens. Its terms are what gets indexed and its
+// offsets are what highlighting uses.
+type Analyzer func(data string) []Token
+
+func (a Analyzer) terms(data string) []string {
+	words := []string{}
+	for _, token := range a(data) {
+		words = append(words, token.Term)
+	}
+	return words
+}
+
+var analyzers = map[string]Analyzer{
+	"whitespace": analyzeWhitespace,
+	"standard":   analyzeStandard,
+}
+
+func getAnalyzer(name string) (Analyzer, error) {
+	analyze, found := analyzers[name]
+	if !found {
+		names := []string{}
+		for name := range analyzers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown tokenizer %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	return analyze, nil
+}
+
+// analyzeWhitespace splits data on spaces and keeps terms as they are. Its
+// terms are the same as getWordsFromData.
+func analyzeWhitespace(data string) []Token {
+	return splitTokens(data, func(r rune) bool { return r == ' ' }, false)
+}
+
+// analyzeStandard lowercases data and splits it on anything that isn't a
+// letter or a digit.
+func analyzeStandard(data string) []Token {
+	return splitTokens(data, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}, true)
+}
+
+func splitTokens(data string, isSeparator func(r rune) bool, lower bool) []Token {
+	tokens := []Token{}
+	start := -1
+	for i := 0; i <= len(data); {
+		r, size := utf8.RuneError, 1
+		if i < len(data) {
+			r, size = utf8.DecodeRuneInString(data[i:])
+		}
+		if i == len(data) || isSeparator(r) {
+			if start >= 0 {
+				term := data[start:i]
+				if lower {
+					term = strings.ToLower(term)
+				}
+				tokens = append(tokens, Token{Term: term, Start: start, End: i})
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+		i += size
+	}
+	return tokens
+}
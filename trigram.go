@@ -0,0 +1,48 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+const gramSize = 3
+
+// getTrigrams returns the distinct lowercased three-rune windows of data.
+// They are the keys of the substring index.
+func getTrigrams(data string) []string {
+	runes := []rune(strings.ToLower(data))
+	grams := []string{}
+	seen := map[string]struct{}{}
+	for i := 0; i+gramSize <= len(runes); i++ {
+		gram := string(runes[i : i+gramSize])
+		if _, found := seen[gram]; !found {
+			seen[gram] = struct{}{}
+			grams = append(grams, gram)
+		}
+	}
+	return grams
+}
+
+// substringPattern matches text case-insensitively, the way the trigram
+// index compares it.
+func substringPattern(text string) *regexp.Regexp {
+	return regexp.MustCompile("(?i)" + regexp.QuoteMeta(text))
+}
+
+// searchSubstring returns the logs whose data contains text, ignoring case.
+// With the trigram index enabled only logs holding every trigram of text are
+// checked; otherwise, or when text is shorter than a trigram, every stored
+// log is scanned.
+func (s *Storage) searchSubstring(text string, limit int, opts SearchOptions) []Log {
+	s.expire()
+	match := s.patternMatcher(substringPattern(text), opts)
+	docs, indexed := s.lookupSubstring(text)
+	if !indexed {
+		return s.collectLogs(s.allDocs(), limit, opts, match)
+	}
+	if opts.IncludeHistory {
+		history, _ := s.history.index.getBySubstring(text)
+		docs = unionEntries(docs, history)
+	}
+	return s.collectLogs(docs, limit, opts, match)
+}
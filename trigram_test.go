@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func Test_getTrigrams(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{"too short", "ab", []string{}},
+		{"lowercased", "TimeOut", []string{"tim", "ime", "meo", "eou", "out"}},
+		{"duplicates dropped", "aaaa", []string{"aaa"}},
+		{"runes", "héllo", []string{"hél", "éll", "llo"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getTrigrams(tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getTrigrams() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvertedIndex_getBySubstring(t *testing.T) {
+	index := getNewIndex()
+	index.enableGrams()
+	first := Log{ID: "1", DocID: 0, Data: "java.net.SocketTimeoutException"}
+	second := Log{ID: "2", DocID: 1, Data: "ReadTimeoutException"}
+	index.update(UpdateOpts{current: &first})
+	index.update(UpdateOpts{current: &second})
+
+	updated := second.copy()
+	updated.Data = "connection refused"
+	index.update(UpdateOpts{previous: &second, current: &updated})
+	if got, indexed := index.getBySubstring("timeout"); !indexed || !reflect.DeepEqual(got, []DocID{0}) {
+		t.Errorf("getBySubstring() after update = %v, %v, want [0], true", got, indexed)
+	}
+
+	index.deletedByLogId(first.DocID)
+	if got, _ := index.getBySubstring("timeout"); len(got) != 0 {
+		t.Errorf("getBySubstring() after delete = %v, want none", got)
+	}
+	if _, indexed := index.getBySubstring("ti"); indexed {
+		t.Errorf("getBySubstring() of a short text should not use the index")
+	}
+}
+
+func TestStorage_searchSubstring(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		opts SearchOptions
+		want []LogID
+	}{
+		{"inside tokens", "timeout", SearchOptions{}, []LogID{"3", "1"}},
+		{"ignores case", "TIMEOUT", SearchOptions{}, []LogID{"3", "1"}},
+		{"across words", "read after", SearchOptions{}, []LogID{"3"}},
+		{"shorter than a trigram", "ok", SearchOptions{}, []LogID{"2"}},
+		{"no match", "refused", SearchOptions{}, nil},
+		{"history", "notfound", SearchOptions{IncludeHistory: true}, []LogID{"2"}},
+	}
+	for _, tt := range tests {
+		for _, trigrams := range []bool{false, true} {
+			s := getNewStore(10)
+			if trigrams {
+				s.enableTrigrams()
+			}
+			s.upsertLog("1", "java.net.SocketTimeoutException")
+			s.upsertLog("2", "GET /api 404 NotFoundError")
+			s.upsertLog("2", "GET /api 200 ok")
+			s.upsertLog("3", "ReadTimeoutException at Client.read after 30s")
+			var got []LogID
+			for _, log := range s.searchSubstring(tt.text, 10, tt.opts) {
+				got = append(got, log.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s: searchSubstring() with trigrams %v = %v, want %v", tt.name, trigrams, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestSession_searchSubstring(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		want     string
+	}{
+		{
+			"substring",
+			[]string{"ADD 1 ReadTimeoutException", "ADD 2 timeout", "ADD 3 ok", "SEARCH *timeout* 5"},
+			"2 1\r\n",
+		},
+		{
+			"highlighted",
+			[]string{"ADD 1 ReadTimeoutException", "SEARCH *timeout* 1 HIGHLIGHT"},
+			"1 Read[Timeout]Exception\r\n",
+		},
+		{
+			"a lone star is a word",
+			[]string{"ADD 1 * marks", "SEARCH * 1"},
+			"1\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := &bytes.Buffer{}
+			store := getNewStore(3)
+			store.enableTrigrams()
+			session := getNewSession(store, output)
+			for _, command := range tt.commands {
+				session.execute(command)
+			}
+			if got := output.String(); got != tt.want {
+				t.Errorf("execute() output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}